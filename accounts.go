@@ -0,0 +1,467 @@
+package weeb
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// User is the default AuthUser implementation used by the accounts module.
+// It is stored in the 'users' table
+type User struct {
+	ID         int64
+	Name       string
+	Email      string
+	Password   string
+	Roles      pq.StringArray
+	TOTPSecret string `db:"totp_secret"`
+	Created    time.Time
+	Updated    time.Time
+}
+
+var ErrorAccountsUserType = errors.New("Current user is not an accounts User")
+
+var _ AuthUser = AuthUser(&User{})
+var _ AuthTOTPUser = AuthTOTPUser(&User{})
+var _ AuthPasswordUpdater = AuthPasswordUpdater(&User{})
+
+func (u *User) Table() string {
+	return "users"
+}
+
+func (u *User) Fields() []string {
+	return []string{"id", "name", "email", "password", "roles", "totp_secret", "created", "updated"}
+}
+
+func (u *User) AuthID() string {
+	return strconv.FormatInt(u.ID, 10)
+}
+
+func (u *User) AuthUsername() string {
+	return u.Email
+}
+
+func (u *User) AuthPassword() string {
+	return u.Password
+}
+
+func (u *User) AuthRoles() []string {
+	return []string(u.Roles)
+}
+
+func (u *User) AuthTOTPSecret() string {
+	return u.TOTPSecret
+}
+
+func (u *User) AuthUpdatePassword(ctx *Context, hash string) error {
+	err := ctx.DB.Exec(`UPDATE users SET password = $1, updated = NOW() WHERE id = $2`, hash, u.ID)
+	if err == nil {
+		u.Password = hash
+	}
+	return err
+}
+
+// Accounts is the optional user accounts module. When the 'accounts' config
+// is set it manages the 'users' table, configures Auth to use it and mounts
+// signup, signin, signout, password reset and two-factor authentication pages
+// under 'accountsPath'.
+//
+// It renders the 'signin', 'signup', 'forgot_password', 'reset_password',
+// 'mail_reset_password', 'two_factor' and 'two_factor_setup' templates
+type Accounts struct {
+	app *App
+}
+
+// NewAccounts creates a new Accounts instance
+func NewAccounts(app *App) *Accounts {
+	return &Accounts{app: app}
+}
+
+// Mount registers the accounts pages on the given router
+func (a *Accounts) Mount(r *Router) {
+	r.Get("/signup/", a.handleSignup)
+	r.Post("/signup/", a.handleSignup)
+	r.Get("/signin/", a.handleSignin)
+	r.Post("/signin/", a.handleSignin)
+	r.Post("/signout/", a.handleSignout)
+	r.Get("/forgot-password/", a.handleForgotPassword)
+	r.Post("/forgot-password/", a.handleForgotPassword)
+	r.Get("/reset-password/{token}/", a.handleResetPassword)
+	r.Post("/reset-password/{token}/", a.handleResetPassword)
+	r.Get("/two-factor/", a.handleTwoFactor)
+	r.Post("/two-factor/", a.handleTwoFactor)
+	r.Get("/two-factor/setup/", a.handleTwoFactorSetup)
+	r.Post("/two-factor/setup/", a.handleTwoFactorSetup)
+	r.Post("/two-factor/disable/", a.handleTwoFactorDisable)
+	r.Post("/stop-impersonating/", a.handleStopImpersonating)
+}
+
+// FindByID finds a user by ID, returning nil if none exist
+func (a *Accounts) FindByID(ctx *Context, id string) (AuthUser, error) {
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	return a.find(ctx, "id", userID)
+}
+
+// FindByUsername finds a user by email, returning nil if none exist
+func (a *Accounts) FindByUsername(ctx *Context, email string) (AuthUser, error) {
+	return a.find(ctx, "email", strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (a *Accounts) find(ctx *Context, field string, value interface{}) (AuthUser, error) {
+	user := &User{}
+	err := ctx.DBHelper.Find(user, FindParams{Where: map[string]interface{}{field: value}})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// LinkOAuth is the OAuth.Link hook used with accounts. It finds the user with
+// the identity's email, creating one if none exist. Identities without a
+// verified email are denied
+func (a *Accounts) LinkOAuth(ctx *Context, identity *OAuthIdentity) (AuthUser, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		ctx.Log.Warning("oauth identity denied", L{"provider": identity.Provider, "err": ErrorOAuthNoEmail.Error()})
+		return nil, nil
+	}
+	user, err := a.FindByUsername(ctx, identity.Email)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	// The user can pick a password later through the password reset pages
+	created, err := a.create(ctx, name, strings.ToLower(identity.Email), generateRandomKey(32))
+	if err != nil {
+		return nil, err
+	}
+	ctx.Log.Info("user signed up", L{"userID": created.ID, "provider": identity.Provider})
+	return created, nil
+}
+
+func (a *Accounts) create(ctx *Context, name, email, password string) (*User, error) {
+	hash, err := ctx.Auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	roles := strings.Split(ctx.Config.Get("accountsDefaultRoles", "user"), ",")
+	user := &User{
+		ID:       ctx.ID.Next(),
+		Name:     name,
+		Email:    email,
+		Password: hash,
+		Roles:    pq.StringArray(roles),
+		Created:  time.Now(),
+		Updated:  time.Now(),
+	}
+	return user, ctx.DBHelper.Insert(user)
+}
+
+func (a *Accounts) path(path string) string {
+	return strings.TrimRight(a.app.Config.Get("accountsPath", "/account/"), "/") + path
+}
+
+func (a *Accounts) handleSignup(ctx *Context) error {
+	name := strings.TrimSpace(ctx.Param("name", ""))
+	email := strings.ToLower(strings.TrimSpace(ctx.Param("email", "")))
+	data := J{"title": "Sign Up", "name": name, "email": email}
+	if ctx.Request.Method == "GET" {
+		return ctx.HTML(200, "signup", data)
+	}
+
+	password := ctx.Param("password", "")
+	v := NewValidator(ctx)
+	v.Validate("name", name, []ValidationFn{ValidatePresence(), ValidateRuneLength(-1, 100)})
+	v.Validate("email", email, []ValidationFn{ValidatePresence(), ValidateEmail(), ValidateUnique("users", "email", "")})
+	v.Validate("password", password, []ValidationFn{ValidatePresence(), ValidateLength(8, 72)})
+	v.Validate("passwordConfirmation", ctx.Param("passwordConfirmation", ""), []ValidationFn{ValidateConfirmation(password)})
+	if !v.Valid() {
+		return ctx.ValidationError(v, "signup", data)
+	}
+
+	user, err := a.create(ctx, name, email, password)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	ctx.Log.Info("user signed up", L{"userID": user.ID})
+
+	ctx.Auth.SigninUser(ctx, user)
+	return ctx.Redirect(ctx.Config.Get("accountsRedirect", "/"))
+}
+
+func (a *Accounts) handleSignin(ctx *Context) error {
+	username := ctx.Param("username", "")
+	data := J{"title": "Login", "username": username, "flashes": ctx.Session.Flashes()}
+	if ctx.Request.Method == "GET" {
+		return ctx.HTML(200, "signin", data)
+	}
+
+	err := ctx.Auth.Signin(ctx, AuthSigninInfo{Username: username, Password: ctx.Param("password", "")})
+	if err == ErrorSecondFactorRequired {
+		return ctx.Redirect(a.path("/two-factor/"))
+	}
+	if err == ErrorUserNotFound || err == ErrorPasswordsDontMatch {
+		data["hasError"] = true
+		return ctx.HTML(422, "signin", data)
+	}
+	if err == ErrorAccountLocked {
+		data["hasError"] = true
+		data["error"] = "Too many failed attempts, please try again later"
+		return ctx.HTML(429, "signin", data)
+	}
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	return ctx.Redirect(ctx.Config.Get("accountsRedirect", "/"))
+}
+
+func (a *Accounts) handleSignout(ctx *Context) error {
+	ctx.Auth.Signout(ctx)
+	return ctx.Redirect("/")
+}
+
+func (a *Accounts) handleStopImpersonating(ctx *Context) error {
+	err := ctx.Auth.StopImpersonating(ctx)
+	if err != nil && err != ErrorNotImpersonating {
+		return ctx.HandleError(err)
+	}
+	return ctx.Redirect(ctx.Config.Get("accountsRedirect", "/"))
+}
+
+func (a *Accounts) handleForgotPassword(ctx *Context) error {
+	email := ctx.Param("email", "")
+	data := J{"title": "Forgot Password", "email": email}
+	if ctx.Request.Method == "GET" {
+		return ctx.HTML(200, "forgot_password", data)
+	}
+
+	user, err := a.FindByUsername(ctx, email)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	// Always display the same message to avoid disclosing which emails have an account
+	data["sent"] = true
+	if user == nil {
+		return ctx.HTML(200, "forgot_password", data)
+	}
+
+	token := generateRandomKey(32)
+	ttl := ctx.Config.GetDuration("accountsResetTtl", time.Hour)
+	err = ctx.DB.Exec(`
+		INSERT INTO password_resets (token_hash, user_id, expires, created) VALUES ($1, $2, $3, NOW())
+	`, hashToken(token), user.(*User).ID, time.Now().Add(ttl))
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+
+	url := ctx.Config.Get("url", "http://localhost:"+ctx.Config.Get("port", "3000")) +
+		a.path("/reset-password/"+token+"/")
+	message, err := ctx.Template("mail_reset_password", J{"url": url, "user": user})
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if err := ctx.Mail.Send(ctx.Config.Get("mailDefaultFrom", ""), user.AuthUsername(), "Reset your password", message); err != nil {
+		return ctx.HandleError(err)
+	}
+	ctx.Log.Info("password reset requested", L{"userID": user.AuthID()})
+	return ctx.HTML(200, "forgot_password", data)
+}
+
+func (a *Accounts) handleResetPassword(ctx *Context) error {
+	token := ctx.Param("token", "")
+	data := J{"title": "Reset Password", "token": token}
+	if ctx.Request.Method == "GET" {
+		return ctx.HTML(200, "reset_password", data)
+	}
+
+	password := ctx.Param("password", "")
+	v := NewValidator(ctx)
+	v.Validate("password", password, []ValidationFn{ValidatePresence(), ValidateLength(8, 72)})
+	v.Validate("passwordConfirmation", ctx.Param("passwordConfirmation", ""), []ValidationFn{ValidateConfirmation(password)})
+	if !v.Valid() {
+		return ctx.ValidationError(v, "reset_password", data)
+	}
+
+	hash, err := ctx.Auth.HashPassword(password)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	// Tokens are single use, mark it as used and change the password in one
+	// transaction so a failing update doesn't burn the token
+	var userID int64
+	err = ctx.DB.Tx(func(tx DB) error {
+		err := tx.QueryOne(&userID, `
+			UPDATE password_resets SET used = NOW()
+			WHERE token_hash = $1 AND used IS NULL AND expires > NOW()
+			RETURNING user_id
+		`, hashToken(token))
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(`UPDATE users SET password = $1, updated = NOW() WHERE id = $2`, hash, userID)
+		if err != nil {
+			return err
+		}
+		return tx.Exec(`DELETE FROM password_resets WHERE user_id = $1 AND used IS NULL`, userID)
+	})
+	if err == sql.ErrNoRows {
+		data["hasError"] = true
+		data["error"] = "This password reset link is invalid or has expired"
+		return ctx.HTML(422, "reset_password", data)
+	}
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	// Sign out every device still using the old password when the session store allows it
+	if err := ctx.Session.RevokeUser(strconv.FormatInt(userID, 10)); err != nil && err != ErrorSessionRevokeUnsupported {
+		return ctx.HandleError(err)
+	}
+	ctx.Log.Info("password reset", L{"userID": userID})
+
+	ctx.Session.AddFlash(FlashSuccess, "Your password was changed, you can now login")
+	return ctx.Redirect(a.path("/signin/"))
+}
+
+func (a *Accounts) handleTwoFactor(ctx *Context) error {
+	user, err := ctx.Auth.PendingUser(ctx)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if user == nil {
+		return ctx.Redirect(a.path("/signin/"))
+	}
+	data := J{"title": "Two-Factor Authentication"}
+	if ctx.Request.Method == "GET" {
+		return ctx.HTML(200, "two_factor", data)
+	}
+
+	err = ctx.Auth.VerifySecondFactor(ctx, ctx.Param("code", ""))
+	if err == ErrorSecondFactorInvalid {
+		data["hasError"] = true
+		return ctx.HTML(422, "two_factor", data)
+	}
+	if err == ErrorAccountLocked {
+		data["hasError"] = true
+		data["error"] = "Too many failed attempts, please try again later"
+		return ctx.HTML(429, "two_factor", data)
+	}
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	return ctx.Redirect(ctx.Config.Get("accountsRedirect", "/"))
+}
+
+// currentUser returns the signed in User, nil when signed out. Auth.FindByID
+// can be replaced so it may return another AuthUser implementation
+func (a *Accounts) currentUser(ctx *Context) (*User, error) {
+	current, err := ctx.Auth.CurrentUser(ctx)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	user, ok := current.(*User)
+	if !ok {
+		return nil, ErrorAccountsUserType
+	}
+	return user, nil
+}
+
+func (a *Accounts) handleTwoFactorSetup(ctx *Context) error {
+	user, err := a.currentUser(ctx)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if user == nil {
+		return ctx.Redirect(a.path("/signin/"))
+	}
+	data := J{"title": "Two-Factor Authentication", "enabled": user.TOTPSecret != ""}
+	if user.TOTPSecret != "" {
+		return ctx.HTML(200, "two_factor_setup", data)
+	}
+
+	// The secret is kept in the session until the user proves their
+	// authenticator app was setup by entering a valid code
+	secret := ctx.Session.Get("totpSetupSecret")
+	if secret == "" {
+		secret = GenerateTOTPSecret()
+		ctx.Session.Set("totpSetupSecret", secret)
+	}
+	issuer := ctx.Config.Get("totpIssuer", ctx.Config.Get("name", "weeb"))
+	data["secret"] = secret
+	data["uri"] = TOTPURI(secret, issuer, user.Email)
+	if ctx.Request.Method == "GET" {
+		return ctx.HTML(200, "two_factor_setup", data)
+	}
+
+	ok, err := ctx.Auth.VerifyTOTP(ctx, user, secret, ctx.Param("code", ""))
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if !ok {
+		data["hasError"] = true
+		return ctx.HTML(422, "two_factor_setup", data)
+	}
+
+	err = ctx.DB.Exec(`UPDATE users SET totp_secret = $1, updated = NOW() WHERE id = $2`, secret, user.ID)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	codes, err := ctx.Auth.GenerateRecoveryCodes(ctx, user)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	ctx.Session.Delete("totpSetupSecret")
+	ctx.Log.Info("two-factor authentication enabled", L{"userID": user.ID})
+	return ctx.HTML(200, "two_factor_setup", J{"title": data["title"], "enabled": true, "recoveryCodes": codes})
+}
+
+func (a *Accounts) handleTwoFactorDisable(ctx *Context) error {
+	user, err := a.currentUser(ctx)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if user == nil {
+		return ctx.Redirect(a.path("/signin/"))
+	}
+	data := J{"title": "Two-Factor Authentication", "enabled": user.TOTPSecret != ""}
+	if user.TOTPSecret == "" {
+		return ctx.Redirect(a.path("/two-factor/setup/"))
+	}
+
+	// Require a valid code so a hijacked session can't disable it
+	err = ctx.Auth.CheckSecondFactor(ctx, user, ctx.Param("code", ""))
+	if err == ErrorSecondFactorInvalid {
+		data["hasError"] = true
+		return ctx.HTML(422, "two_factor_setup", data)
+	}
+	if err == ErrorAccountLocked {
+		data["hasError"] = true
+		data["error"] = "Too many failed attempts, please try again later"
+		return ctx.HTML(429, "two_factor_setup", data)
+	}
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+
+	err = ctx.DB.Exec(`UPDATE users SET totp_secret = '', updated = NOW() WHERE id = $1`, user.ID)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if err := ctx.Auth.DeleteRecoveryCodes(ctx, user); err != nil {
+		return ctx.HandleError(err)
+	}
+	ctx.Log.Info("two-factor authentication disabled", L{"userID": user.ID})
+	return ctx.Redirect(a.path("/two-factor/setup/"))
+}
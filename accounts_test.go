@@ -0,0 +1,88 @@
+package weeb
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postResetPassword posts a new password for the given reset token
+func postResetPassword(app *App, token string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {"new password"}, "passwordConfirmation": {"new password"}}
+	req := httptest.NewRequest("POST", "/test-reset/"+token+"/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(app, req)
+}
+
+func newTestResetPasswordApp(t *testing.T, db DB) *App {
+	app := newTestApp(t, map[string]string{"APP_ACCOUNTS": "1", "APP_BCRYPT_COST": "4"})
+	app.DB = db
+	r := app.Router.Group("/test-reset/")
+	r.SkipCSRF()
+	r.Post("/{token}/", app.Accounts.handleResetPassword)
+	return app
+}
+
+func TestAccountsResetPasswordInTransaction(t *testing.T) {
+	db := &txRecordingDB{&recordingDB{
+		OnQueryOne: func(dest interface{}, query string, args []interface{}) error {
+			*dest.(*int64) = 42
+			return nil
+		},
+	}}
+	app := newTestResetPasswordApp(t, db)
+
+	if w := postResetPassword(app, "token"); w.Code != 302 {
+		t.Fatalf("expected a redirect to signin, got %d", w.Code)
+	}
+	queries := db.statementQueries()
+	if len(queries) != 5 || queries[0] != "BEGIN" || !strings.HasPrefix(queries[1], "UPDATE password_resets") ||
+		!strings.HasPrefix(queries[2], "UPDATE users SET password") || queries[4] != "COMMIT" {
+		t.Fatalf("expected the token and password to be updated in one transaction, got %v", queries)
+	}
+	if args := db.executed("UPDATE users")[0].args; args[1] != int64(42) {
+		t.Fatalf("expected the token's user to be updated, got %v", args)
+	}
+}
+
+func TestAccountsResetPasswordRollsBackTheToken(t *testing.T) {
+	db := &txRecordingDB{&recordingDB{
+		OnQueryOne: func(dest interface{}, query string, args []interface{}) error {
+			*dest.(*int64) = 42
+			return nil
+		},
+		OnExec: func(query string, args []interface{}) error {
+			if strings.Contains(query, "UPDATE users") {
+				return errors.New("connection reset")
+			}
+			return nil
+		},
+	}}
+	app := newTestResetPasswordApp(t, db)
+
+	if w := postResetPassword(app, "token"); w.Code != 500 {
+		t.Fatalf("expected a 500, got %d", w.Code)
+	}
+	// The token stays usable when the password couldn't be changed
+	if queries := db.statementQueries(); queries[len(queries)-1] != "ROLLBACK" {
+		t.Fatalf("expected the transaction to be rolled back, got %v", queries)
+	}
+}
+
+func TestAccountsTwoFactorRefusesOtherUserTypes(t *testing.T) {
+	app := newTestApp(t, map[string]string{"APP_ACCOUNTS": "1"})
+	handlers := map[string]HandlerFunc{
+		"setup":   app.Accounts.handleTwoFactorSetup,
+		"disable": app.Accounts.handleTwoFactorDisable,
+	}
+	for name, handler := range handlers {
+		withTestContext(app, func(ctx *Context) {
+			ctx.Set("currentUser", &testTOTPUser{testUser{id: "1"}})
+			if err := handler(ctx); err != nil || ctx.StatusCode() != 500 {
+				t.Errorf("expected %s to fail with a 500 for a non accounts user, got %d (%v)", name, ctx.StatusCode(), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package weeb
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// APIToken represents a personal API token. Only a hash of the token is
+// stored, the token itself is only known when it's issued
+type APIToken struct {
+	ID       int64
+	UserID   string
+	Name     string
+	LastUsed *time.Time
+	Expires  *time.Time
+	Created  time.Time
+}
+
+// IssueToken creates a new API token for the given user and returns it. It
+// expires after 'apiTokenTtl' (never by default)
+func (a *Auth) IssueToken(ctx *Context, user AuthUser, name string) (string, error) {
+	token := generateRandomKey(40)
+	var expires *time.Time
+	if ttl := ctx.Config.GetDuration("apiTokenTtl", 0); ttl > 0 {
+		t := time.Now().Add(ttl)
+		expires = &t
+	}
+	err := ctx.DB.Exec(`
+		INSERT INTO api_tokens (id, user_id, name, token_hash, expires, created) VALUES ($1, $2, $3, $4, $5, NOW())
+	`, ctx.ID.Next(), user.AuthID(), name, hashToken(token), expires)
+	if err != nil {
+		return "", err
+	}
+	ctx.Log.Info("api token issued", L{"userID": user.AuthID(), "name": name})
+	return token, nil
+}
+
+// RevokeToken deletes the given API token
+func (a *Auth) RevokeToken(ctx *Context, token string) error {
+	return ctx.DB.Exec(`DELETE FROM api_tokens WHERE token_hash = $1`, hashToken(token))
+}
+
+// RevokeTokenByID deletes one of the given user's API tokens
+func (a *Auth) RevokeTokenByID(ctx *Context, user AuthUser, id int64) error {
+	return ctx.DB.Exec(`DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, id, user.AuthID())
+}
+
+// Tokens lists the given user's API tokens
+func (a *Auth) Tokens(ctx *Context, user AuthUser) ([]*APIToken, error) {
+	tokens := []*APIToken{}
+	err := ctx.DB.QueryAll(&tokens, `
+		SELECT id, user_id, name, last_used, expires, created FROM api_tokens WHERE user_id = $1 ORDER BY created
+	`, user.AuthID())
+	return tokens, err
+}
+
+// userFromToken finds the user owning a valid API token, returning nil if
+// there is none
+func (a *Auth) userFromToken(ctx *Context, token string) (AuthUser, error) {
+	userID := ""
+	err := ctx.DB.QueryOne(&userID, `
+		UPDATE api_tokens SET last_used = NOW()
+		WHERE token_hash = $1 AND (expires IS NULL OR expires > NOW())
+		RETURNING user_id
+	`, hashToken(token))
+	if err == sql.ErrNoRows {
+		ctx.Log.Warning("invalid api token", L{})
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.FindByID(ctx, userID)
+}
+
+// bearerToken returns the token from the request's 'Authorization: Bearer'
+// header, if any
+func bearerToken(ctx *Context) string {
+	if ctx.Request == nil {
+		return ""
+	}
+	header := ctx.Request.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
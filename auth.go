@@ -2,8 +2,6 @@ package weeb
 
 import (
 	"errors"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var authUserKey contextKey = 1
@@ -28,14 +26,23 @@ type Auth struct {
 	app            *App
 	FindByID       func(ctx *Context, id string) (AuthUser, error)
 	FindByUsername func(ctx *Context, username string) (AuthUser, error)
+	OAuth          *OAuth
+	PasswordHasher PasswordHasher
+
+	jwt         *JWT
+	permissions map[string][]string
+	policies    map[string]PolicyFn
 }
 
 func NewAuth(app *App) *Auth {
-	return &Auth{
+	auth := &Auth{
 		app:            app,
 		FindByID:       authDefaultFindByID,
 		FindByUsername: authDefaultFindByUsername,
+		PasswordHasher: NewPasswordHasher(app.Config),
 	}
+	auth.OAuth = NewOAuth(auth)
+	return auth
 }
 
 func (a *Auth) RequireRoles(roles ...string) func(HandlerFunc) HandlerFunc {
@@ -61,32 +68,84 @@ func (a *Auth) RequireRoles(roles ...string) func(HandlerFunc) HandlerFunc {
 }
 
 func (a *Auth) Signin(ctx *Context, info AuthSigninInfo) error {
+	throttle := newSigninThrottle(ctx, info.Username)
+	if err := throttle.locked(); err != nil {
+		return err
+	}
+
 	user, err := a.FindByUsername(ctx, info.Username)
 	if err != nil {
 		return err
 	}
 	if user == nil {
+		if err := throttle.failed("user not found"); err != nil {
+			return err
+		}
 		return ErrorUserNotFound
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.AuthPassword()), []byte(info.Password))
-	if err != nil {
+	ok, err := a.VerifyPassword(user.AuthPassword(), info.Password)
+	if err != nil && err != ErrorUnknownPasswordHash {
+		return err
+	}
+	if !ok {
+		if err := throttle.failed("wrong password"); err != nil {
+			return err
+		}
 		return ErrorPasswordsDontMatch
 	}
+	a.rehashPassword(ctx, user, info.Password)
 
-	if !info.OnlyValidate {
-		a.SigninUser(ctx, user)
+	if totpSecret(user) != "" && !info.OnlyValidate {
+		// Wait for VerifySecondFactor before considering the user signed in,
+		// it resets the failed attempts once the code is valid
+		ctx.Session.Set("pendingUserID", user.AuthID())
+		return ErrorSecondFactorRequired
+	}
+	if err := throttle.succeeded(); err != nil {
+		return err
+	}
+	if info.OnlyValidate {
+		return nil
 	}
+	a.SigninUser(ctx, user)
 
 	return nil
 }
 
+// SigninUser makes `user` the session's user. The session gets a new ID and
+// CSRF token to prevent session fixation
 func (a *Auth) SigninUser(ctx *Context, user AuthUser) {
+	a.regenerateSession(ctx)
 	ctx.Set("currentUser", user)
 	ctx.Session.Set("userID", user.AuthID())
+	setSignedIn(ctx, user)
 }
 
 func (a *Auth) Signout(ctx *Context) {
+	a.regenerateSession(ctx)
 	ctx.Session.Set("userID", "")
+	ctx.Session.Delete("pendingUserID")
+	ctx.Session.Delete("impersonatorID")
+	delete(ctx.Data, "currentUser")
+	setSignedIn(ctx, nil)
+}
+
+func (a *Auth) regenerateSession(ctx *Context) {
+	if err := ctx.Session.Regenerate(); err != nil {
+		ctx.Log.Error("error regenerating session", L{"err": err.Error()})
+	}
+}
+
+// setSignedIn sets the 'currentUserID' and 'isSignedIn' context values
+// templates and middlewares use
+func setSignedIn(ctx *Context, user AuthUser) {
+	if user == nil {
+		ctx.Set("currentUserID", "")
+		ctx.Set("isSignedIn", false)
+		return
+	}
+	ctx.Set("currentUserID", user.AuthID())
+	ctx.Set("isSignedIn", true)
 }
 
 func (a *Auth) CurrentUser(ctx *Context) (AuthUser, error) {
@@ -94,12 +153,20 @@ func (a *Auth) CurrentUser(ctx *Context) (AuthUser, error) {
 		return user.(AuthUser), nil
 	}
 
-	userID := ctx.Session.Get("userID")
-	if userID == "" {
+	var user AuthUser
+	var err error
+	if token := bearerToken(ctx); isJWT(token) {
+		// JWTs are only trusted once verified by JWTMiddleware
 		return nil, nil
+	} else if token != "" {
+		user, err = a.userFromToken(ctx, token)
+	} else {
+		userID := ctx.Session.Get("userID")
+		if userID == "" {
+			return nil, nil
+		}
+		user, err = a.FindByID(ctx, userID)
 	}
-
-	user, err := a.FindByID(ctx, userID)
 	if err == nil && user != nil {
 		ctx.Set("currentUser", user)
 	}
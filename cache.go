@@ -1,8 +1,11 @@
 package weeb
 
 import (
+	"container/list"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -10,34 +13,206 @@ type Cache interface {
 	Get(key string, value interface{}) error
 	Set(key string, result interface{}, ttl time.Duration) error
 	Del(key string) error
+	// Incr atomically increments the integer at key and returns it's new
+	// value. A missing key starts at 0 and expires after ttl, increments
+	// don't extend it. Added for the signin throttle: Cache implementations
+	// outside this package need to add it
+	Incr(key string, ttl time.Duration) (int64, error)
 }
 
 var CacheKeyNotFoundError = errors.New("Cache key not found.")
+var CacheValueTooLargeError = errors.New("Cache value is larger than the cache's size limit.")
 
+// memoryCacheSweepInterval is how often Set removes all expired entries, so
+// entries that are never read again don't pile up
+const memoryCacheSweepInterval = time.Minute
+
+// CacheStats holds counters about a cache's usage
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+	Entries     int   `json:"entries"`
+	Bytes       int   `json:"bytes"`
+}
+
+// MemoryCache is an in process Cache implementation safe for concurrent use.
+// Once it holds more than `maxEntries` entries or `maxBytes` bytes it evicts
+// the least recently used entries. A limit of 0 means no limit. Expired
+// entries are removed when read and swept every minute on Set
 type MemoryCache struct {
-	contents map[string][]byte
+	mutex      sync.Mutex
+	maxEntries int
+	maxBytes   int
+	entries    map[string]*list.Element
+	lru        *list.List
+	stats      CacheStats
+	lastSweep  time.Time
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
 }
 
+var _ Cache = Cache(&MemoryCache{})
+
+// NewMemoryCache creates a new MemoryCache with no size limits
 func NewMemoryCache() *MemoryCache {
-	return &MemoryCache{contents: map[string][]byte{}}
+	return NewMemoryCacheWithLimits(0, 0)
+}
+
+// NewMemoryCacheWithLimits creates a new MemoryCache evicting entries once
+// it holds more than `maxEntries` entries or `maxBytes` bytes of values
+func NewMemoryCacheWithLimits(maxEntries, maxBytes int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		lastSweep:  time.Now(),
+	}
 }
 
 func (c *MemoryCache) Get(key string, result interface{}) error {
-	if bytes, ok := c.contents[key]; !ok {
+	c.mutex.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		c.mutex.Unlock()
+		return CacheKeyNotFoundError
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(element)
+		c.stats.Expirations++
+		c.stats.Misses++
+		c.mutex.Unlock()
 		return CacheKeyNotFoundError
-	} else {
-		return json.Unmarshal(bytes, result)
 	}
+	c.lru.MoveToFront(element)
+	c.stats.Hits++
+	bytes := entry.value
+	c.mutex.Unlock()
+
+	return json.Unmarshal(bytes, result)
 }
+
 func (c *MemoryCache) Set(key string, value interface{}, ttl time.Duration) error {
-	if bytes, err := json.Marshal(value); err != nil {
+	bytes, err := json.Marshal(value)
+	if err != nil {
 		return err
-	} else {
-		c.contents[key] = bytes
-		return nil
 	}
+
+	entry := &memoryCacheEntry{key: key, value: bytes}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	if c.maxBytes > 0 && len(bytes) > c.maxBytes {
+		return CacheValueTooLargeError
+	}
+	if time.Since(c.lastSweep) > memoryCacheSweepInterval {
+		c.sweep()
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.stats.Bytes += len(entry.value)
+	c.evict()
+	return nil
 }
+
 func (c *MemoryCache) Del(key string) error {
-	delete(c.contents, key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
 	return nil
 }
+
+func (c *MemoryCache) Incr(key string, ttl time.Duration) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := int64(0)
+	expires := time.Time{}
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*memoryCacheEntry)
+		if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+			c.stats.Expirations++
+		} else {
+			if err := json.Unmarshal(entry.value, &count); err != nil {
+				return 0, err
+			}
+			expires = entry.expires
+		}
+		c.remove(element)
+	}
+	count++
+
+	entry := &memoryCacheEntry{key: key, value: []byte(strconv.FormatInt(count, 10)), expires: expires}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.stats.Bytes += len(entry.value)
+	c.evict()
+	return count, nil
+}
+
+// Stats returns a snapshot of the cache's usage counters
+func (c *MemoryCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// evict removes entries from the least recently used end of the list until
+// the cache is within it's limits. It must be called with the mutex held
+func (c *MemoryCache) evict() {
+	for c.lru.Len() > 0 && c.overLimits() {
+		element := c.lru.Back()
+		entry := element.Value.(*memoryCacheEntry)
+		c.remove(element)
+		if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+			c.stats.Expirations++
+		} else {
+			c.stats.Evictions++
+		}
+	}
+}
+
+// sweep removes all expired entries. It must be called with the mutex held
+func (c *MemoryCache) sweep() {
+	now := time.Now()
+	for element := c.lru.Back(); element != nil; {
+		previous := element.Prev()
+		entry := element.Value.(*memoryCacheEntry)
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			c.remove(element)
+			c.stats.Expirations++
+		}
+		element = previous
+	}
+	c.lastSweep = now
+}
+
+func (c *MemoryCache) overLimits() bool {
+	return (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.stats.Bytes > c.maxBytes)
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*memoryCacheEntry)
+	delete(c.entries, entry.key)
+	c.stats.Bytes -= len(entry.value)
+}
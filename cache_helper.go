@@ -0,0 +1,144 @@
+package weeb
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// CacheComputeFn computes the value for a cache key missing from the cache
+type CacheComputeFn func() (interface{}, error)
+
+// CacheHelper represents a cache helper providing utility functions around
+// a cache's basic Get and Set methods
+type CacheHelper struct {
+	cache  Cache
+	logger *Logger
+	mutex  sync.Mutex
+	calls  map[string]*cacheHelperCall
+}
+
+type cacheHelperCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// cacheHelperEntry is what FetchStale stores in the cache, the value and the
+// time after which it needs to be refreshed
+type cacheHelperEntry struct {
+	Value      json.RawMessage `json:"value"`
+	FreshUntil time.Time       `json:"freshUntil"`
+}
+
+// NewCacheHelper create a new instance of a cache helper associated to the
+// given cache. Cache errors are logged to `logger` and don't fail fetches
+func NewCacheHelper(cache Cache, logger *Logger) *CacheHelper {
+	return &CacheHelper{cache: cache, logger: logger, calls: map[string]*cacheHelperCall{}}
+}
+
+// Fetch gets `key` from the cache into `dest`. On a miss it calls `fn`, stores
+// it's result for `ttl` and decodes it into `dest`. Concurrent misses for the
+// same key only call `fn` once. When the cache is unavailable `fn` is called
+// every time
+func (h *CacheHelper) Fetch(key string, ttl time.Duration, dest interface{}, fn CacheComputeFn) error {
+	value := json.RawMessage{}
+	err := h.cache.Get(key, &value)
+	if err == nil {
+		return json.Unmarshal(value, dest)
+	}
+	if err != CacheKeyNotFoundError {
+		h.logError("error reading cache", key, err)
+	}
+
+	bytes, err := h.do(key, func() ([]byte, error) {
+		bytes, err := h.compute(fn)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.cache.Set(key, json.RawMessage(bytes), ttl); err != nil {
+			h.logError("error writing cache", key, err)
+		}
+		return bytes, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, dest)
+}
+
+// FetchStale works like Fetch but keeps values in the cache for an extra
+// `stale` duration after `ttl`. During that time the stale value is returned
+// right away while it is recomputed in the background
+func (h *CacheHelper) FetchStale(key string, ttl, stale time.Duration, dest interface{}, fn CacheComputeFn) error {
+	refresh := func() ([]byte, error) {
+		bytes, err := h.compute(fn)
+		if err != nil {
+			return nil, err
+		}
+		entry := &cacheHelperEntry{Value: bytes, FreshUntil: time.Now().Add(ttl)}
+		if err := h.cache.Set(key, entry, ttl+stale); err != nil {
+			h.logError("error writing cache", key, err)
+		}
+		return bytes, nil
+	}
+
+	entry := &cacheHelperEntry{}
+	err := h.cache.Get(key, entry)
+	if err == nil {
+		if time.Now().After(entry.FreshUntil) {
+			go func() {
+				if _, err := h.do(key, refresh); err != nil {
+					h.logError("error refreshing stale cache value", key, err)
+				}
+			}()
+		}
+		return json.Unmarshal(entry.Value, dest)
+	}
+	if err != CacheKeyNotFoundError {
+		h.logError("error reading cache", key, err)
+	}
+
+	bytes, err := h.do(key, refresh)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, dest)
+}
+
+func (h *CacheHelper) logError(msg, key string, err error) {
+	if h.logger != nil {
+		h.logger.Error(msg, L{"key": key, "err": err.Error()})
+	}
+}
+
+func (h *CacheHelper) compute(fn CacheComputeFn) ([]byte, error) {
+	value, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// do calls `fn` unless a call for the same key is already in flight, in which
+// case it waits for it and returns it's result instead
+func (h *CacheHelper) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	h.mutex.Lock()
+	if call, ok := h.calls[key]; ok {
+		h.mutex.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &cacheHelperCall{done: make(chan struct{})}
+	h.calls[key] = call
+	h.mutex.Unlock()
+
+	defer func() {
+		h.mutex.Lock()
+		delete(h.calls, key)
+		h.mutex.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
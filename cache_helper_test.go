@@ -0,0 +1,87 @@
+package weeb
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// brokenCache is a Cache whose every call fails, like an unreachable redis
+type brokenCache struct{}
+
+func (brokenCache) Get(key string, value interface{}) error { return errors.New("cache down") }
+func (brokenCache) Set(key string, v interface{}, ttl time.Duration) error {
+	return errors.New("cache down")
+}
+func (brokenCache) Del(key string) error { return errors.New("cache down") }
+func (brokenCache) Incr(key string, ttl time.Duration) (int64, error) {
+	return 0, errors.New("cache down")
+}
+
+// logRecorder captures the messages logged to a Logger
+type logRecorder struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func newLogRecorder() (*Logger, *logRecorder) {
+	recorder := &logRecorder{}
+	logger := NewLogger()
+	logger.ClearOutputs(nil)
+	logger.AddOutput(func(line string) {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		recorder.lines = append(recorder.lines, line)
+	})
+	return logger, recorder
+}
+
+func (r *logRecorder) contains(text string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, line := range r.lines {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCacheHelperFetchWithBrokenCache(t *testing.T) {
+	logger, logs := newLogRecorder()
+	helper := NewCacheHelper(brokenCache{}, logger)
+
+	value := ""
+	err := helper.Fetch("key", time.Minute, &value, func() (interface{}, error) {
+		return "computed", nil
+	})
+	if err != nil || value != "computed" {
+		t.Fatalf("expected the computed value, got %q (%v)", value, err)
+	}
+	if !logs.contains("error writing cache") || !logs.contains("error reading cache") {
+		t.Fatalf("expected cache errors to be logged, got %v", logs.lines)
+	}
+}
+
+func TestCacheHelperFetchStaleLogsRefreshErrors(t *testing.T) {
+	logger, logs := newLogRecorder()
+	cache := NewMemoryCache()
+	helper := NewCacheHelper(cache, logger)
+	cache.Set("key", &cacheHelperEntry{Value: []byte(`"stale"`), FreshUntil: time.Now().Add(-time.Second)}, 0)
+
+	value := ""
+	err := helper.FetchStale("key", time.Minute, time.Minute, &value, func() (interface{}, error) {
+		return nil, errors.New("compute failed")
+	})
+	if err != nil || value != "stale" {
+		t.Fatalf("expected the stale value, got %q (%v)", value, err)
+	}
+	for i := 0; i < 100 && !logs.contains("compute failed"); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !logs.contains("error refreshing stale cache value") {
+		t.Fatalf("expected the refresh error to be logged, got %v", logs.lines)
+	}
+}
@@ -0,0 +1,261 @@
+package weeb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisCache is a Cache implementation storing values in a redis server. It
+// speaks the redis protocol (RESP) directly over TCP
+type RedisCache struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	conns    chan *redisConn
+}
+
+var _ Cache = Cache(&RedisCache{})
+
+// RedisError represents an error reply sent back by the redis server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisCache creates a new RedisCache instance from a redis url of the
+// form 'redis://:password@host:port/db'. At most `poolSize` idle connections
+// are kept open
+func NewRedisCache(redisURL string, poolSize int) (*RedisCache, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("RedisCache: unsupported url scheme '%s'", u.Scheme)
+	}
+
+	c := &RedisCache{addr: u.Host, timeout: 5 * time.Second, conns: make(chan *redisConn, poolSize)}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if c.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("RedisCache: invalid database number '%s'", path)
+		}
+	}
+	return c, nil
+}
+
+func (c *RedisCache) Get(key string, result interface{}) error {
+	reply, err := c.Do("GET", key)
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return CacheKeyNotFoundError
+	}
+	bytes, ok := reply.([]byte)
+	if !ok {
+		return fmt.Errorf("redis: unexpected reply type %T for GET", reply)
+	}
+	return json.Unmarshal(bytes, result)
+}
+
+func (c *RedisCache) Set(key string, value interface{}, ttl time.Duration) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		if ms == 0 {
+			ms = 1
+		}
+		_, err = c.Do("SET", key, string(bytes), "PX", strconv.FormatInt(ms, 10))
+	} else {
+		_, err = c.Do("SET", key, string(bytes))
+	}
+	return err
+}
+
+func (c *RedisCache) Del(key string) error {
+	_, err := c.Do("DEL", key)
+	return err
+}
+
+// redisIncrScript increments KEYS[1] and sets it's expiry to ARGV[1]
+// milliseconds when it creates it. Scripts run atomically so the counter
+// can't be left without an expiry
+const redisIncrScript = `local count = redis.call('INCR', KEYS[1])
+if count == 1 and tonumber(ARGV[1]) > 0 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count`
+
+// Incr runs INCR, and PEXPIRE when the key is created, in one script
+func (c *RedisCache) Incr(key string, ttl time.Duration) (int64, error) {
+	ms := int64(0)
+	if ttl > 0 {
+		if ms = int64(ttl / time.Millisecond); ms == 0 {
+			ms = 1
+		}
+	}
+	reply, err := c.Do("EVAL", redisIncrScript, "1", key, strconv.FormatInt(ms, 10))
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply type %T for INCR", reply)
+	}
+	return count, nil
+}
+
+// Do sends a command to redis and returns it's reply. Replies are either
+// nil, a string (status), an int64, a []byte (bulk string) or an
+// []interface{} (array) whose error elements are RedisErrors
+func (c *RedisCache) Do(args ...string) (interface{}, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(c.timeout, args...)
+	if _, isRedisError := err.(RedisError); err != nil && !isRedisError {
+		// The connection is in an unknown state, don't reuse it
+		conn.Close()
+		return nil, err
+	}
+	c.release(conn)
+	return reply, err
+}
+
+func (c *RedisCache) conn() (*redisConn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, r: bufio.NewReader(netConn)}
+	if c.password != "" {
+		if _, err := conn.do(c.timeout, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.do(c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *RedisCache) release(conn *redisConn) {
+	select {
+	case c.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (conn *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cmd := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		cmd += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	return conn.readReply()
+}
+
+func (conn *redisConn) readLine() (string, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: malformed reply line")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (conn *redisConn) readReply() (interface{}, error) {
+	line, err := conn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i], err = conn.readReply()
+			if redisErr, ok := err.(RedisError); ok {
+				// Error elements (e.g. in EXEC replies) are values, the rest
+				// of the array still has to be read to keep the connection usable
+				values[i] = redisErr
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply '%s'", line)
+}
@@ -0,0 +1,369 @@
+package weeb
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process redis server speaking enough RESP for
+// RedisCache: AUTH, SELECT, GET, SET (with PX), DEL and EVAL of the Incr
+// script. EXEC and BAD reply with an array holding an error and a malformed
+// reply
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mutex    sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	commands [][]string
+	conns    int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{
+		listener: listener,
+		password: password,
+		values:   map[string]string{},
+		expires:  map[string]time.Time{},
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeRedis) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns++
+		s.mutex.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		args, err := readFakeRedisCommand(r)
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.commands = append(s.commands, args)
+		s.mutex.Unlock()
+
+		name := strings.ToUpper(args[0])
+		if name == "AUTH" {
+			if len(args) == 2 && args[1] == s.password {
+				authed = true
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+			}
+			continue
+		}
+		if !authed {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		io.WriteString(conn, s.reply(name, args[1:]))
+	}
+}
+
+func (s *fakeRedis) reply(name string, args []string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch name {
+	case "SELECT":
+		if n, err := strconv.Atoi(args[0]); err != nil || n > 15 {
+			return "-ERR DB index is out of range\r\n"
+		}
+		return "+OK\r\n"
+	case "GET":
+		value, ok := s.values[args[0]]
+		if expires, hasTTL := s.expires[args[0]]; ok && hasTTL && time.Now().After(expires) {
+			delete(s.values, args[0])
+			ok = false
+		}
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	case "SET":
+		s.values[args[0]] = args[1]
+		delete(s.expires, args[0])
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			s.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "EVAL":
+		if args[0] != redisIncrScript || args[1] != "1" {
+			return "-ERR unknown script\r\n"
+		}
+		key := args[2]
+		if expires, hasTTL := s.expires[key]; hasTTL && time.Now().After(expires) {
+			delete(s.values, key)
+			delete(s.expires, key)
+		}
+		n, err := strconv.ParseInt(s.values[key], 10, 64)
+		if _, ok := s.values[key]; ok && err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		s.values[key] = strconv.FormatInt(n+1, 10)
+		if ms, _ := strconv.Atoi(args[3]); n == 0 && ms > 0 {
+			s.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return ":" + s.values[key] + "\r\n"
+	case "DEL":
+		_, ok := s.values[args[0]]
+		delete(s.values, args[0])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "EXEC":
+		return "*3\r\n+OK\r\n-WRONGTYPE wrong kind of value\r\n:1\r\n"
+	case "BAD":
+		return "?\r\n"
+	}
+	return "-ERR unknown command '" + name + "'\r\n"
+}
+
+func (s *fakeRedis) lastCommand(name string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := len(s.commands) - 1; i >= 0; i-- {
+		if strings.EqualFold(s.commands[i][0], name) {
+			return s.commands[i]
+		}
+	}
+	return nil
+}
+
+func readFakeRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestRedisCacheGetSetDel(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache, err := NewRedisCache("redis://"+server.addr(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value map[string]int
+	if err := cache.Get("missing", &value); err != CacheKeyNotFoundError {
+		t.Fatalf("expected CacheKeyNotFoundError, got %v", err)
+	}
+	if err := cache.Set("key", map[string]int{"a": 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if command := server.lastCommand("SET"); len(command) != 3 {
+		t.Fatalf("expected SET without expiry, got %v", command)
+	}
+	if err := cache.Get("key", &value); err != nil || value["a"] != 1 {
+		t.Fatalf("expected {a: 1}, got %v (%v)", value, err)
+	}
+	if err := cache.Del("key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Get("key", &value); err != CacheKeyNotFoundError {
+		t.Fatalf("expected CacheKeyNotFoundError after Del, got %v", err)
+	}
+}
+
+func TestRedisCacheSetTTL(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache, _ := NewRedisCache("redis://"+server.addr(), 2)
+
+	if err := cache.Set("key", "value", 1500*time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	command := server.lastCommand("SET")
+	if len(command) != 5 || command[3] != "PX" || command[4] != "1" {
+		t.Fatalf("expected SET key value PX 1, got %v", command)
+	}
+	time.Sleep(5 * time.Millisecond)
+	var value string
+	if err := cache.Get("key", &value); err != CacheKeyNotFoundError {
+		t.Fatalf("expected the key to expire, got %q (%v)", value, err)
+	}
+}
+
+func TestRedisCacheIncr(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache, _ := NewRedisCache("redis://"+server.addr(), 2)
+
+	for i := int64(1); i <= 3; i++ {
+		if count, err := cache.Incr("counter", time.Minute); err != nil || count != i {
+			t.Fatalf("expected %d, got %d (%v)", i, count, err)
+		}
+	}
+	// The script sets the expiry, in the same command as the increment
+	if command := server.lastCommand("EVAL"); len(command) != 5 || command[3] != "counter" || command[4] != "60000" {
+		t.Fatalf("expected EVAL <script> 1 counter 60000, got %v", command)
+	}
+	server.mutex.Lock()
+	expires := server.expires["counter"]
+	server.mutex.Unlock()
+	if remaining := time.Until(expires); remaining <= 0 || remaining > time.Minute {
+		t.Fatalf("expected the counter to expire within a minute, got %s", remaining)
+	}
+	var count int64
+	if err := cache.Get("counter", &count); err != nil || count != 3 {
+		t.Fatalf("expected Get to read 3, got %d (%v)", count, err)
+	}
+	cache.Set("text", "abc", 0)
+	if _, err := cache.Incr("text", 0); err == nil {
+		t.Fatal("expected an error incrementing a non integer")
+	}
+}
+
+func TestRedisCacheAuthAndSelect(t *testing.T) {
+	server := newFakeRedis(t, "s3cret")
+	cache, _ := NewRedisCache("redis://:s3cret@"+server.addr()+"/3", 2)
+
+	if err := cache.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if command := server.lastCommand("AUTH"); len(command) != 2 || command[1] != "s3cret" {
+		t.Fatalf("expected AUTH s3cret, got %v", command)
+	}
+	if command := server.lastCommand("SELECT"); len(command) != 2 || command[1] != "3" {
+		t.Fatalf("expected SELECT 3, got %v", command)
+	}
+}
+
+func TestRedisCacheErrorReplies(t *testing.T) {
+	server := newFakeRedis(t, "s3cret")
+
+	cache, _ := NewRedisCache("redis://:wrong@"+server.addr(), 2)
+	var value string
+	err := cache.Get("key", &value)
+	if _, ok := err.(RedisError); !ok || !strings.HasPrefix(err.Error(), "redis: WRONGPASS") {
+		t.Fatalf("expected a WRONGPASS RedisError, got %v", err)
+	}
+
+	cache, _ = NewRedisCache("redis://"+server.addr(), 2)
+	if _, err := cache.Do("GET", "key"); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Fatalf("expected a NOAUTH error, got %v", err)
+	}
+
+	cache, _ = NewRedisCache("redis://:s3cret@"+server.addr()+"/99", 2)
+	if err := cache.Set("key", "value", 0); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("expected a SELECT error, got %v", err)
+	}
+
+	// Error replies leave the connection usable so it goes back in the pool
+	cache, _ = NewRedisCache("redis://:s3cret@"+server.addr(), 2)
+	if _, err := cache.Do("NOPE"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("expected an unknown command error, got %v", err)
+	}
+	server.mutex.Lock()
+	conns := server.conns
+	server.mutex.Unlock()
+	if err := cache.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.conns != conns {
+		t.Fatalf("expected the connection to be reused, %d connections were opened", server.conns-conns)
+	}
+}
+
+func TestNewRedisCacheURL(t *testing.T) {
+	cache, err := NewRedisCache("redis://:pw@example.com/2", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.addr != "example.com:6379" || cache.password != "pw" || cache.db != 2 {
+		t.Fatalf("unexpected cache settings %+v", cache)
+	}
+	if _, err := NewRedisCache("http://example.com", 1); err == nil {
+		t.Fatal("expected an error for a non redis url")
+	}
+	if _, err := NewRedisCache("redis://example.com/abc", 1); err == nil {
+		t.Fatal("expected an error for an invalid database number")
+	}
+}
+
+func TestRedisCacheArrayErrorReplies(t *testing.T) {
+	server := newFakeRedis(t, "")
+	cache, _ := NewRedisCache("redis://"+server.addr(), 2)
+	cache.Set("key", "value", 0)
+
+	// The whole array is read, leaving nothing behind for the next command
+	reply, err := cache.Do("EXEC")
+	values, ok := reply.([]interface{})
+	if err != nil || !ok || len(values) != 3 {
+		t.Fatalf("expected an array of 3 values, got %v (%v)", reply, err)
+	}
+	if _, isRedisError := values[1].(RedisError); values[0] != "OK" || !isRedisError || values[2] != int64(1) {
+		t.Fatalf("expected [OK WRONGTYPE 1], got %v", values)
+	}
+	var value string
+	if err := cache.Get("key", &value); err != nil || value != "value" {
+		t.Fatalf("expected the pooled connection to read 'value', got %q (%v)", value, err)
+	}
+
+	// Protocol errors close the connection instead of pooling it
+	server.mutex.Lock()
+	conns := server.conns
+	server.mutex.Unlock()
+	if _, err := cache.Do("BAD"); err == nil {
+		t.Fatal("expected an error for a malformed reply")
+	}
+	if err := cache.Get("key", &value); err != nil || value != "value" {
+		t.Fatalf("expected 'value', got %q (%v)", value, err)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.conns != conns+1 {
+		t.Fatalf("expected a new connection after the malformed reply, %d were opened", server.conns-conns)
+	}
+}
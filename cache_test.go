@@ -0,0 +1,86 @@
+package weeb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheSweepsExpiredEntries(t *testing.T) {
+	cache := NewMemoryCache()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.Set(key, key, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.Set("kept", "kept", 0)
+	time.Sleep(2 * time.Millisecond)
+
+	cache.mutex.Lock()
+	cache.lastSweep = time.Now().Add(-2 * memoryCacheSweepInterval)
+	cache.mutex.Unlock()
+	cache.Set("new", "new", 0)
+
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Expirations != 3 {
+		t.Fatalf("expected 2 entries and 3 expirations, got %+v", stats)
+	}
+	var value string
+	if err := cache.Get("kept", &value); err != nil || value != "kept" {
+		t.Fatalf("expected 'kept' to stay cached, got %q (%v)", value, err)
+	}
+}
+
+func TestMemoryCacheRejectsValuesOverMaxBytes(t *testing.T) {
+	cache := NewMemoryCacheWithLimits(0, 10)
+	cache.Set("key", "small", 0)
+	if err := cache.Set("key", "much too large", 0); err != CacheValueTooLargeError {
+		t.Fatalf("expected CacheValueTooLargeError, got %v", err)
+	}
+	var value string
+	if err := cache.Get("key", &value); err != CacheKeyNotFoundError {
+		t.Fatalf("expected the previous value to be removed, got %q (%v)", value, err)
+	}
+	if stats := cache.Stats(); stats.Bytes != 0 {
+		t.Fatalf("expected 0 bytes used, got %d", stats.Bytes)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCacheWithLimits(2, 0)
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	var value int
+	cache.Get("a", &value)
+	cache.Set("c", 3, 0)
+
+	if err := cache.Get("b", &value); err != CacheKeyNotFoundError {
+		t.Fatalf("expected 'b' to be evicted, got %v", err)
+	}
+	if err := cache.Get("a", &value); err != nil || value != 1 {
+		t.Fatalf("expected 'a' to be kept, got %d (%v)", value, err)
+	}
+}
+
+func TestMemoryCacheIncr(t *testing.T) {
+	cache := NewMemoryCache()
+	for i := int64(1); i <= 3; i++ {
+		if count, err := cache.Incr("counter", 20*time.Millisecond); err != nil || count != i {
+			t.Fatalf("expected %d, got %d (%v)", i, count, err)
+		}
+	}
+	var count int64
+	if err := cache.Get("counter", &count); err != nil || count != 3 {
+		t.Fatalf("expected Get to read 3, got %d (%v)", count, err)
+	}
+
+	// Increments don't extend the expiry
+	time.Sleep(25 * time.Millisecond)
+	if count, err := cache.Incr("counter", time.Minute); err != nil || count != 1 {
+		t.Fatalf("expected the counter to restart at 1, got %d (%v)", count, err)
+	}
+
+	cache.Set("text", "abc", 0)
+	if _, err := cache.Incr("text", 0); err == nil {
+		t.Fatal("expected an error incrementing a non integer")
+	}
+}
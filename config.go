@@ -3,7 +3,9 @@ package weeb
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config represents a config instance
@@ -34,6 +36,26 @@ func (c *Config) GetBool(key string) bool {
 	return value == "1" || value == "true"
 }
 
+// GetInt gets an integer value for a config key defaulting to `alternative`
+// if non-present or not a valid integer
+func (c *Config) GetInt(key string, alternative int) int {
+	value, err := strconv.Atoi(c.Get(key, ""))
+	if err != nil {
+		return alternative
+	}
+	return value
+}
+
+// GetDuration gets a duration value (e.g. '30s', '5m') for a config key
+// defaulting to `alternative` if non-present or not a valid duration
+func (c *Config) GetDuration(key string, alternative time.Duration) time.Duration {
+	value, err := time.ParseDuration(c.Get(key, ""))
+	if err != nil {
+		return alternative
+	}
+	return value
+}
+
 // Get gets a config value defaulting to `alternative` if non-present
 func (c *Config) Get(key, alternative string) string {
 	value, ok := c.values[key]
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"strings"
 
@@ -17,18 +18,22 @@ type Context struct {
 	app        *App
 	statusCode int
 	body       string
+	locale     string
 	Request    *http.Request
 	Response   http.ResponseWriter
 
-	Data     J
-	DB       DB
-	DBHelper *DBHelper
-	Log      *Logger
-	Session  *Session
-	Config   *Config
-	Auth     *Auth
-	ID       *id.Gen
-	Mail     Mailer
+	Data        J
+	Cache       Cache
+	CacheHelper *CacheHelper
+	DB          DB
+	DBHelper    *DBHelper
+	Log         *Logger
+	Session     *Session
+	Config      *Config
+	Auth        *Auth
+	ID          *id.Gen
+	Mail        Mailer
+	Jobs        *Jobs
 }
 
 func NewHTTPContext(app *App, w http.ResponseWriter, r *http.Request) *Context {
@@ -36,6 +41,9 @@ func NewHTTPContext(app *App, w http.ResponseWriter, r *http.Request) *Context {
 
 	ctx.Request = r.WithContext(context.WithValue(r.Context(), requestContextKey, ctx))
 	ctx.Response = w
+	// Queries are cancelled when the client disconnects
+	ctx.DB = app.DB.WithContext(ctx.Request.Context())
+	ctx.DBHelper = NewDBHelper(ctx.DB)
 
 	return ctx
 }
@@ -44,6 +52,8 @@ func NewContext(app *App) *Context {
 	ctx := &Context{app: app, statusCode: 200}
 
 	ctx.Data = J{}
+	ctx.Cache = app.Cache
+	ctx.CacheHelper = app.CacheHelper
 	ctx.DB = app.DB
 	ctx.DBHelper = NewDBHelper(app.DB)
 	ctx.Log = app.Log.WithContext(L{})
@@ -52,6 +62,7 @@ func NewContext(app *App) *Context {
 	ctx.Auth = app.Auth
 	ctx.ID = app.ID
 	ctx.Mail = app.Mail
+	ctx.Jobs = app.Jobs
 
 	return ctx
 }
@@ -128,6 +139,27 @@ func (ctx *Context) StatusCode() int {
 	return ctx.statusCode
 }
 
+// ClientIP returns the IP address of the client. The 'X-Forwarded-For' and
+// 'X-Real-IP' headers are only used when the 'trustProxy' config is set
+func (ctx *Context) ClientIP() string {
+	if ctx.Request == nil {
+		return ""
+	}
+	if ctx.Config.GetBool("trustProxy") {
+		if forwarded := ctx.Request.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if realIP := ctx.Request.Header.Get("X-Real-IP"); realIP != "" {
+			return realIP
+		}
+	}
+	host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		return ctx.Request.RemoteAddr
+	}
+	return host
+}
+
 func (ctx *Context) finalizeResponse() {
 	ctx.Session.save()
 	ctx.Response.WriteHeader(ctx.statusCode)
@@ -158,10 +190,33 @@ func (ctx *Context) Bind(entity interface{}) error {
 		for key := range ctx.Request.Form {
 			formData[key] = ctx.Request.Form.Get(key)
 		}
-		return mapstructure.Decode(formData, entity)
+		// Form values are all strings, let mapstructure convert them to the
+		// entity's field types
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			WeaklyTypedInput: true,
+			Result:           entity,
+		})
+		if err != nil {
+			return err
+		}
+		return decoder.Decode(formData)
 	} else if strings.Contains(ctx.Request.Header.Get("Content-Type"), "application/json") {
 		return json.NewDecoder(ctx.Request.Body).Decode(entity)
 	} else {
 		return errors.New("Unsupported Content-Type provided")
 	}
 }
+
+// BindAndValidate parses the request body into `entity` then validates it
+// using it's `validate` struct tags. A non-nil error means the request
+// couldn't be parsed, validation errors are reported by the returned Validator
+func (ctx *Context) BindAndValidate(entity interface{}) (*Validator, error) {
+	if err := ctx.Bind(entity); err != nil {
+		return nil, err
+	}
+	v := NewValidator(ctx)
+	if err := v.ValidateStruct(entity); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
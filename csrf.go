@@ -0,0 +1,106 @@
+package weeb
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"strings"
+)
+
+const (
+	csrfFieldName  = "_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfTokenFn is what the 'csrfToken' context value holds, tokens are only
+// created when rendered
+type csrfTokenFn func() string
+
+// CSRFToken returns the current session's CSRF token, creating one if needed.
+// The token is specific to the session so the response is marked
+// 'Cache-Control: private, no-store'
+func (ctx *Context) CSRFToken() string {
+	token := ctx.Session.Get("csrfToken")
+	if token == "" {
+		token = generateRandomKey(32)
+		ctx.Session.Set("csrfToken", token)
+	}
+	if ctx.Response != nil {
+		ctx.SetHeader("Cache-Control", "private, no-store")
+	}
+	return token
+}
+
+// SkipCSRF disables CSRF checks for all routes under this router's prefix.
+// Useful for JSON API groups authenticated by 'Authorization: Bearer' tokens.
+// Checks are only skipped for requests bearing a token or no session cookie,
+// so a cross-site form can't use a signed in visitor's cookie on those routes
+func (r *Router) SkipCSRF() {
+	r.app.csrfExemptPrefixes = append(r.app.csrfExemptPrefixes, r.prefix)
+}
+
+// csrfMiddleware rejects POST, PUT, PATCH and DELETE requests that don't
+// provide the session's CSRF token in the '_csrf' form field or the
+// 'X-CSRF-Token' header
+func csrfMiddleware(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		ctx.Set("csrfToken", csrfTokenFn(ctx.CSRFToken))
+
+		switch ctx.Request.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+			return next(ctx)
+		}
+		if csrfExempt(ctx) {
+			return next(ctx)
+		}
+
+		sent := ctx.Request.Header.Get(csrfHeaderName)
+		if sent == "" {
+			sent = ctx.Request.FormValue(csrfFieldName)
+		}
+		token := ctx.Session.Get("csrfToken")
+		if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			ctx.Log.Warning("invalid csrf token", L{"method": ctx.Request.Method, "path": ctx.Request.URL.Path})
+			return ctx.Error(403, "invalid csrf token")
+		}
+		return next(ctx)
+	}
+}
+
+// csrfExempt tells if the request is under a SkipCSRF prefix and isn't
+// authenticated by the session cookie
+func csrfExempt(ctx *Context) bool {
+	for _, prefix := range ctx.app.csrfExemptPrefixes {
+		if strings.HasPrefix(ctx.Request.URL.Path, prefix) {
+			// CurrentUser ignores the session when there is a bearer token
+			_, err := ctx.Request.Cookie(ctx.Session.name())
+			return bearerToken(ctx) != "" || err != nil
+		}
+	}
+	return false
+}
+
+// csrfField is the 'csrfField' template function. It renders a hidden input
+// holding the CSRF token: `{{ csrfField . }}`
+func csrfField(data interface{}) template.HTML {
+	token := csrfToken(data)
+	return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// csrfToken is the 'csrfToken' template function returning the CSRF token,
+// e.g. for a meta tag read by scripts: `{{ csrfToken . }}`
+func csrfToken(data interface{}) string {
+	var value interface{}
+	switch values := data.(type) {
+	case J:
+		value = values["csrfToken"]
+	case map[string]interface{}:
+		value = values["csrfToken"]
+	}
+	switch token := value.(type) {
+	case csrfTokenFn:
+		return token()
+	case string:
+		return token
+	}
+	return ""
+}
@@ -1,7 +1,14 @@
 package weeb
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -16,88 +23,317 @@ type DB interface {
 	Exec(string, ...interface{}) error
 	ExecNamed(string, interface{}) error
 	ExecWithResult(string, ...interface{}) (sql.Result, error)
+	// Context variants, cancelling the query when ctx is done
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryOneContext(context.Context, interface{}, string, ...interface{}) error
+	QueryAllContext(context.Context, interface{}, string, ...interface{}) error
+	ExecContext(context.Context, string, ...interface{}) error
+	ExecNamedContext(context.Context, string, interface{}) error
+	ExecWithResultContext(context.Context, string, ...interface{}) (sql.Result, error)
+	// WithContext returns a DB whose methods without a context use ctx
+	WithContext(ctx context.Context) DB
+	// Tx runs fn in a transaction, committed when fn returns nil and rolled
+	// back otherwise. Calling Tx on the DB given to fn uses a savepoint
+	Tx(fn func(tx DB) error) error
+	// Ping checks the database is reachable
+	Ping(ctx context.Context) error
+	// Stats returns the connection pool's statistics
+	Stats() sql.DBStats
 }
 
-// PostgresDB
+// PostgresDB is a DB connecting lazily to postgres. The pool settings need
+// to be set before the first query
 type PostgresDB struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
 	dbURL  string
-	db     *sqlx.DB
+	conn   *postgresConn
+	ctx    context.Context
+	tx     *sqlx.Tx
+	depth  int
 	logger *Logger
 }
 
+// postgresConn holds the lazily opened connection pool so copies of a
+// PostgresDB (like transactions) share it. The mutex is only held while
+// creating the pool, which doesn't touch the network
+type postgresConn struct {
+	sync.Mutex
+	opened *sqlx.DB
+	db     atomic.Value
+}
+
+// pool returns the connection pool or nil when not connected yet
+func (c *postgresConn) pool() *sqlx.DB {
+	db, _ := c.db.Load().(*sqlx.DB)
+	return db
+}
+
 func NewPostgresDB(dbURL string, logger *Logger) *PostgresDB {
-	return &PostgresDB{dbURL: dbURL, logger: logger}
+	return &PostgresDB{
+		MaxIdleConns: 2,
+		dbURL:        dbURL,
+		conn:         &postgresConn{},
+		ctx:          context.Background(),
+		logger:       logger,
+	}
 }
 
+// Connect checks the database is reachable, once, so requests fail fast
+// while it's down. ConnectWithRetries is meant for startup
 func (db *PostgresDB) Connect() error {
-	if db.db != nil {
+	if db.conn.pool() != nil {
 		return nil
 	}
+	pool, err := db.open()
+	if err != nil {
+		return err
+	}
+	if err := pool.PingContext(db.ctx); err != nil {
+		return err
+	}
+	db.conn.db.Store(pool)
+	return nil
+}
+
+// open creates the connection pool the first time it's called
+func (db *PostgresDB) open() (*sqlx.DB, error) {
+	db.conn.Lock()
+	defer db.conn.Unlock()
+	if db.conn.opened != nil {
+		return db.conn.opened, nil
+	}
 
 	dataSourceName, err := pq.ParseURL(db.dbURL)
 	if err != nil {
+		return nil, err
+	}
+	pool, err := sqlx.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	pool.SetMaxOpenConns(db.MaxOpenConns)
+	pool.SetMaxIdleConns(db.MaxIdleConns)
+	pool.SetConnMaxLifetime(db.ConnMaxLifetime)
+	db.conn.opened = pool
+	return pool, nil
+}
+
+const maxConnectBackoff = 30 * time.Second
+
+// ConnectWithRetries tries connecting `attempts` times, waiting `backoff`
+// and then twice as long between tries, until ctx is done. For use at
+// startup while the database might still be starting
+func ConnectWithRetries(ctx context.Context, db DB, attempts int, backoff time.Duration, logger *Logger) error {
+	db = db.WithContext(ctx)
+	for attempt := 1; ; attempt++ {
+		err := db.Connect()
+		if err == nil || attempt >= attempts || ctx.Err() != nil {
+			return err
+		}
+		logger.Warning("error connecting to database", L{"attempt": attempt, "retryIn": backoff.String(), "err": err.Error()})
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// Ping connects if needed and checks the database is reachable
+func (db *PostgresDB) Ping(ctx context.Context) error {
+	if err := db.WithContext(ctx).Connect(); err != nil {
 		return err
 	}
-	db.db, err = sqlx.Connect("postgres", dataSourceName)
-	return err
+	return db.conn.pool().PingContext(ctx)
 }
 
-func (db *PostgresDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+// Stats returns the connection pool's statistics, they are all zero until
+// connected
+func (db *PostgresDB) Stats() sql.DBStats {
+	if pool := db.conn.pool(); pool != nil {
+		return pool.Stats()
+	}
+	return sql.DBStats{}
+}
+
+// WithContext returns a copy of the database sharing it's connection pool
+// (and transaction) that runs queries with ctx
+func (db *PostgresDB) WithContext(ctx context.Context) DB {
+	ctxDB := *db
+	ctxDB.ctx = ctx
+	return &ctxDB
+}
+
+// ext returns the transaction when in one, the connection pool otherwise
+func (db *PostgresDB) ext() (sqlx.ExtContext, error) {
+	if db.tx != nil {
+		return db.tx, nil
+	}
 	if err := db.Connect(); err != nil {
 		return nil, err
 	}
+	return db.conn.pool(), nil
+}
+
+func (db *PostgresDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(db.ctx, query, args...)
+}
+
+func (db *PostgresDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ext, err := db.ext()
+	if err != nil {
+		return nil, err
+	}
 	db.logger.Debug("sql", L{"query": query, "args": args})
-	return db.db.Query(query, args...)
+	return ext.QueryContext(ctx, query, args...)
 }
 
 func (db *PostgresDB) QueryOne(dest interface{}, query string, args ...interface{}) error {
-	if err := db.Connect(); err != nil {
+	return db.QueryOneContext(db.ctx, dest, query, args...)
+}
+
+func (db *PostgresDB) QueryOneContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ext, err := db.ext()
+	if err != nil {
 		return err
 	}
 	db.logger.Debug("sql", L{"query": query, "args": args})
-	return db.db.Get(dest, query, args...)
+	return sqlx.GetContext(ctx, ext, dest, query, args...)
 }
 
 func (db *PostgresDB) QueryAll(dest interface{}, query string, args ...interface{}) error {
-	if err := db.Connect(); err != nil {
+	return db.QueryAllContext(db.ctx, dest, query, args...)
+}
+
+func (db *PostgresDB) QueryAllContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ext, err := db.ext()
+	if err != nil {
 		return err
 	}
 	db.logger.Debug("sql", L{"query": query, "args": args})
-	return db.db.Select(dest, query, args...)
+	return sqlx.SelectContext(ctx, ext, dest, query, args...)
 }
 
 func (db *PostgresDB) QueryRow(dest []interface{}, query string, args ...interface{}) error {
-	if err := db.Connect(); err != nil {
+	ext, err := db.ext()
+	if err != nil {
 		return err
 	}
 
 	db.logger.Debug("sql", L{"query": query, "args": args})
-	row := db.db.QueryRow(query, args...)
+	row := ext.QueryRowxContext(db.ctx, query, args...)
 	return row.Scan(dest...)
 }
 
 func (db *PostgresDB) Exec(query string, args ...interface{}) error {
-	_, err := db.ExecWithResult(query, args...)
+	return db.ExecContext(db.ctx, query, args...)
+}
+
+func (db *PostgresDB) ExecContext(ctx context.Context, query string, args ...interface{}) error {
+	_, err := db.ExecWithResultContext(ctx, query, args...)
 	return err
 }
 
 func (db *PostgresDB) ExecNamed(query string, arg interface{}) error {
-	if err := db.Connect(); err != nil {
+	return db.ExecNamedContext(db.ctx, query, arg)
+}
+
+func (db *PostgresDB) ExecNamedContext(ctx context.Context, query string, arg interface{}) error {
+	ext, err := db.ext()
+	if err != nil {
 		return err
 	}
 
 	db.logger.Debug("sql", L{"query": query, "arg": arg})
-	_, err := db.db.NamedExec(query, arg)
+	_, err = sqlx.NamedExecContext(ctx, ext, query, arg)
 	return err
 }
 
 func (db *PostgresDB) ExecWithResult(query string, args ...interface{}) (sql.Result, error) {
-	if err := db.Connect(); err != nil {
+	return db.ExecWithResultContext(db.ctx, query, args...)
+}
+
+func (db *PostgresDB) ExecWithResultContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ext, err := db.ext()
+	if err != nil {
 		return nil, err
 	}
 
 	db.logger.Debug("sql", L{"query": query, "args": args})
-	return db.db.Exec(query, args...)
+	return ext.ExecContext(ctx, query, args...)
+}
+
+// Tx runs fn in a transaction. Nested calls use savepoints so an inner
+// failure only rolls back the inner statements. Panics roll back too
+func (db *PostgresDB) Tx(fn func(tx DB) error) (err error) {
+	txDB := *db
+	txDB.depth = db.depth + 1
+	savepoint := fmt.Sprintf("sp_%d", txDB.depth)
+
+	if db.tx == nil {
+		if err := db.Connect(); err != nil {
+			return err
+		}
+		db.logger.Debug("sql", L{"query": "BEGIN"})
+		if txDB.tx, err = db.conn.pool().BeginTxx(db.ctx, nil); err != nil {
+			return err
+		}
+	} else if err := db.Exec("SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			txDB.rollback(savepoint)
+			panic(r)
+		}
+		if err != nil {
+			// A cancelled context already rolled the transaction back
+			if rollbackErr := txDB.rollback(savepoint); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+				db.logger.Error("error rolling back transaction", L{"err": rollbackErr.Error()})
+			}
+			return
+		}
+		err = txDB.commit(savepoint)
+	}()
+	return fn(&txDB)
+}
+
+func (db *PostgresDB) rollback(savepoint string) error {
+	if db.depth > 1 {
+		return db.Exec("ROLLBACK TO SAVEPOINT " + savepoint)
+	}
+	db.logger.Debug("sql", L{"query": "ROLLBACK"})
+	return db.tx.Rollback()
+}
+
+func (db *PostgresDB) commit(savepoint string) error {
+	if db.depth > 1 {
+		return db.Exec("RELEASE SAVEPOINT " + savepoint)
+	}
+	db.logger.Debug("sql", L{"query": "COMMIT"})
+	return db.tx.Commit()
+}
+
+// postgresStatementTimeout sets the server side 'statement_timeout' of the
+// connections made with dbURL, unless the URL already sets one
+func postgresStatementTimeout(dbURL string, timeout time.Duration) (string, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if query.Get("statement_timeout") == "" {
+		query.Set("statement_timeout", strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
 }
 
 type DBStringArray pq.StringArray
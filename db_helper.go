@@ -25,6 +25,13 @@ func NewDBHelper(db DB) *DBHelper {
 	return &DBHelper{db: db}
 }
 
+// Tx runs fn with a helper over a transaction, see DB.Tx
+func (h *DBHelper) Tx(fn func(h *DBHelper) error) error {
+	return h.db.Tx(func(tx DB) error {
+		return fn(NewDBHelper(tx))
+	})
+}
+
 // Insert inserts given entity in the database
 func (h *DBHelper) Insert(e Entity) error {
 	insertSQL := "INSERT INTO %s (%s) VALUES (%s)"
@@ -57,6 +64,29 @@ type FindParams struct {
 	Where      map[string]interface{}
 }
 
+// Count counts the rows of `table` matching the given filters, ignoring the
+// row with `exceptID` as ID if it's not empty
+func (h *DBHelper) Count(table string, where map[string]interface{}, exceptID string) (int64, error) {
+	sql := "SELECT COUNT(*) FROM " + table
+	values := []interface{}{}
+	whereSqls := []string{}
+	for whereField, whereValue := range where {
+		values = append(values, whereValue)
+		whereSqls = append(whereSqls, fmt.Sprintf("%s = $%d", ToSnakeCase(whereField), len(values)))
+	}
+	if exceptID != "" {
+		values = append(values, exceptID)
+		whereSqls = append(whereSqls, fmt.Sprintf("id <> $%d", len(values)))
+	}
+	if len(whereSqls) > 0 {
+		sql += " WHERE " + strings.Join(whereSqls, " AND ")
+	}
+
+	var count int64
+	err := h.db.QueryOne(&count, sql, values...)
+	return count, err
+}
+
 func (h *DBHelper) findSQLFor(e Entity, params FindParams) (string, []interface{}) {
 	sql := "SELECT %s FROM %s"
 	values := []interface{}{}
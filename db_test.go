@@ -0,0 +1,168 @@
+package weeb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingDB is a DB recording the statements run through it. Exec and
+// QueryOne are answered by the optional hooks, QueryOne returns
+// sql.ErrNoRows without one
+type recordingDB struct {
+	DB
+	OnExec     func(query string, args []interface{}) error
+	OnQueryOne func(dest interface{}, query string, args []interface{}) error
+
+	mutex      sync.Mutex
+	statements []recordedStatement
+}
+
+type recordedStatement struct {
+	query string
+	args  []interface{}
+}
+
+func (db *recordingDB) record(query string, args []interface{}) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.statements = append(db.statements, recordedStatement{query, args})
+}
+
+// executed returns the statements whose query contains `part`
+func (db *recordingDB) executed(part string) []recordedStatement {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	statements := []recordedStatement{}
+	for _, statement := range db.statements {
+		if strings.Contains(statement.query, part) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func (db *recordingDB) Exec(query string, args ...interface{}) error {
+	db.record(query, args)
+	if db.OnExec != nil {
+		return db.OnExec(query, args)
+	}
+	return nil
+}
+
+func (db *recordingDB) QueryOne(dest interface{}, query string, args ...interface{}) error {
+	db.record(query, args)
+	if db.OnQueryOne != nil {
+		return db.OnQueryOne(dest, query, args)
+	}
+	return sql.ErrNoRows
+}
+
+func (db *recordingDB) WithContext(ctx context.Context) DB {
+	return db
+}
+
+// txRecordingDB is a recordingDB recording transactions as BEGIN and
+// COMMIT/ROLLBACK statements. QueryAll finds nothing, e.g. no applied
+// migrations
+type txRecordingDB struct {
+	*recordingDB
+}
+
+func (db *txRecordingDB) QueryAll(dest interface{}, query string, args ...interface{}) error {
+	db.record(query, args)
+	return nil
+}
+
+func (db *txRecordingDB) WithContext(ctx context.Context) DB {
+	return db
+}
+
+func (db *txRecordingDB) Tx(fn func(tx DB) error) error {
+	db.record("BEGIN", nil)
+	if err := fn(db); err != nil {
+		db.record("ROLLBACK", nil)
+		return err
+	}
+	db.record("COMMIT", nil)
+	return nil
+}
+
+// statementQueries returns the trimmed queries recorded, without the ones
+// listing applied migrations
+func (db *txRecordingDB) statementQueries() []string {
+	queries := []string{}
+	for _, statement := range db.executed("") {
+		if !strings.Contains(statement.query, "CREATE TABLE IF NOT EXISTS migrations") &&
+			!strings.HasPrefix(statement.query, "SELECT id FROM migrations") {
+			queries = append(queries, strings.TrimSpace(statement.query))
+		}
+	}
+	return queries
+}
+
+// flakyDB is a DB whose Connect fails `fails` times
+type flakyDB struct {
+	DB
+	fails    int
+	attempts int
+}
+
+func (db *flakyDB) Connect() error {
+	db.attempts++
+	if db.attempts <= db.fails {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (db *flakyDB) WithContext(ctx context.Context) DB {
+	return db
+}
+
+func TestConnectWithRetries(t *testing.T) {
+	logger := NewLogger()
+	logger.ClearOutputs(nil)
+
+	db := &flakyDB{fails: 2}
+	if err := ConnectWithRetries(context.Background(), db, 5, time.Millisecond, logger); err != nil || db.attempts != 3 {
+		t.Fatalf("expected to connect on the 3rd attempt, got %d attempts (%v)", db.attempts, err)
+	}
+
+	db = &flakyDB{fails: 10}
+	if err := ConnectWithRetries(context.Background(), db, 3, time.Millisecond, logger); err == nil || db.attempts != 3 {
+		t.Fatalf("expected to give up after 3 attempts, got %d attempts (%v)", db.attempts, err)
+	}
+
+	db = &flakyDB{fails: 10}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ConnectWithRetries(ctx, db, 5, time.Hour, logger); err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatalf("expected the backoff to stop with ctx, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestPostgresDBConnectTriesOnce(t *testing.T) {
+	logger := NewLogger()
+	logger.ClearOutputs(nil)
+	db := NewPostgresDB("postgres://postgres@127.0.0.1:1/app?sslmode=disable", logger)
+
+	start := time.Now()
+	if err := db.Connect(); err == nil {
+		t.Fatal("expected an error connecting to a closed port")
+	}
+	if err := db.Exec("SELECT 1"); err == nil {
+		t.Fatal("expected queries to fail while the database is down")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected to fail fast, took %s", elapsed)
+	}
+	if db.conn.pool() != nil {
+		t.Fatal("expected the pool not to be marked connected")
+	}
+}
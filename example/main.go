@@ -35,7 +35,7 @@ func main() {
 }
 
 func handleRedirectToLogin(ctx *weeb.Context) error {
-	return ctx.Redirect("/signin/")
+	return ctx.Redirect("/account/signin/")
 }
 
 func handle404(ctx *weeb.Context) error {
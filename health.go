@@ -0,0 +1,55 @@
+package weeb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// HealthzHandler is a handler for load balancer or orchestrator health
+// checks. It responds 200 when the database can be pinged within the
+// 'healthzTimeout' config (5s by default) and 503 otherwise, e.g.
+// `app.Router.Get("/healthz", weeb.HealthzHandler)`
+func HealthzHandler(ctx *Context) error {
+	timeout := ctx.Config.GetDuration("healthzTimeout", 5*time.Second)
+	pingCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+	defer cancel()
+
+	ctx.SetHeader("Cache-Control", "no-store")
+	if err := ctx.DB.Ping(pingCtx); err != nil {
+		ctx.Log.Error("health check failed", L{"err": err.Error()})
+		return ctx.JSON(503, J{"status": "error", "database": "unavailable"})
+	}
+	return ctx.JSON(200, J{"status": "ok", "database": "ok"})
+}
+
+// DBStatsValues returns the database connection pool's statistics with
+// lower camel case names, for logging or metrics endpoints
+func DBStatsValues(stats sql.DBStats) J {
+	return J{
+		"maxOpenConnections": stats.MaxOpenConnections,
+		"openConnections":    stats.OpenConnections,
+		"inUse":              stats.InUse,
+		"idle":               stats.Idle,
+		"waitCount":          stats.WaitCount,
+		"waitDuration":       stats.WaitDuration.String(),
+		"maxIdleClosed":      stats.MaxIdleClosed,
+		"maxIdleTimeClosed":  stats.MaxIdleTimeClosed,
+		"maxLifetimeClosed":  stats.MaxLifetimeClosed,
+	}
+}
+
+// logDBStats logs the connection pool's statistics every `interval` until
+// ctx is done
+func (app *App) logDBStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			app.Log.Info("db stats", L(DBStatsValues(app.DB.Stats())))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
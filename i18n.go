@@ -0,0 +1,514 @@
+package weeb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PluralRule picks which of a language's plural forms to use for a count.
+// Categories are in gettext's msgstr[n] order
+type PluralRule struct {
+	Categories []string
+	Form       func(n int) int
+}
+
+var pluralRuleOneOther = PluralRule{
+	Categories: []string{"one", "other"},
+	Form: func(n int) int {
+		if n == 1 {
+			return 0
+		}
+		return 1
+	},
+}
+
+var pluralRuleOther = PluralRule{
+	Categories: []string{"other"},
+	Form:       func(n int) int { return 0 },
+}
+
+var pluralRuleSlavic = PluralRule{
+	Categories: []string{"one", "few", "many"},
+	Form: func(n int) int {
+		if n%10 == 1 && n%100 != 11 {
+			return 0
+		}
+		if n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20) {
+			return 1
+		}
+		return 2
+	},
+}
+
+// PluralRules are the plural rules of each language, languages missing
+// from it use English's. Apps can register their own
+var PluralRules = map[string]PluralRule{
+	"en": pluralRuleOneOther,
+	"de": pluralRuleOneOther,
+	"es": pluralRuleOneOther,
+	"it": pluralRuleOneOther,
+	"nl": pluralRuleOneOther,
+	"pt": pluralRuleOneOther,
+	"sv": pluralRuleOneOther,
+	"fr": {
+		Categories: []string{"one", "other"},
+		Form: func(n int) int {
+			if n == 0 || n == 1 {
+				return 0
+			}
+			return 1
+		},
+	},
+	"ru": pluralRuleSlavic,
+	"uk": pluralRuleSlavic,
+	"pl": {
+		Categories: []string{"one", "few", "many"},
+		Form: func(n int) int {
+			if n == 1 {
+				return 0
+			}
+			if n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20) {
+				return 1
+			}
+			return 2
+		},
+	},
+	"cs": {
+		Categories: []string{"one", "few", "other"},
+		Form: func(n int) int {
+			if n == 1 {
+				return 0
+			}
+			if n >= 2 && n <= 4 {
+				return 1
+			}
+			return 2
+		},
+	},
+	"ja": pluralRuleOther,
+	"ko": pluralRuleOther,
+	"zh": pluralRuleOther,
+}
+
+func pluralRuleFor(locale string) PluralRule {
+	if rule, ok := PluralRules[locale]; ok {
+		return rule
+	}
+	if rule, ok := PluralRules[localeLanguage(locale)]; ok {
+		return rule
+	}
+	return pluralRuleOneOther
+}
+
+// localeLanguage returns the language part of a locale, "fr" for "fr-CA"
+func localeLanguage(locale string) string {
+	return strings.SplitN(strings.Replace(locale, "_", "-", -1), "-", 2)[0]
+}
+
+// Catalog holds the translated messages of a locale. Each message has one
+// form per plural category of the locale, or a single one
+type Catalog struct {
+	Locale   string
+	rule     PluralRule
+	messages map[string][]string
+}
+
+// NewCatalog creates an empty catalog for the given locale
+func NewCatalog(locale string) *Catalog {
+	return &Catalog{Locale: locale, rule: pluralRuleFor(locale), messages: map[string][]string{}}
+}
+
+// Set sets the forms of a message
+func (c *Catalog) Set(key string, forms ...string) {
+	c.messages[key] = forms
+}
+
+// LoadJSON loads messages from json. Nested objects are flattened with '.'
+// in keys and objects keyed by plural categories hold plural forms:
+//
+//	{"inbox": {"title": "Inbox", "messages": {"one": "{count} message", "other": "{count} messages"}}}
+func (c *Catalog) LoadJSON(contents []byte) error {
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(contents, &values); err != nil {
+		return err
+	}
+	return c.loadJSONValues("", values)
+}
+
+func (c *Catalog) loadJSONValues(prefix string, values map[string]interface{}) error {
+	for key, value := range values {
+		switch value := value.(type) {
+		case string:
+			c.Set(prefix+key, value)
+		case map[string]interface{}:
+			if forms, ok := c.pluralForms(value); ok {
+				c.Set(prefix+key, forms...)
+			} else if err := c.loadJSONValues(prefix+key+".", value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("I18n: invalid value for '%s%s' in %s catalog", prefix, key, c.Locale)
+		}
+	}
+	return nil
+}
+
+// pluralForms returns the forms of an object keyed by plural categories
+func (c *Catalog) pluralForms(values map[string]interface{}) ([]string, bool) {
+	other, ok := values["other"].(string)
+	if !ok {
+		return nil, false
+	}
+	for key := range values {
+		if !containsString([]string{"zero", "one", "two", "few", "many", "other"}, key) {
+			return nil, false
+		}
+	}
+	forms := []string{}
+	for _, category := range c.rule.Categories {
+		form, ok := values[category].(string)
+		if !ok {
+			form = other
+		}
+		forms = append(forms, form)
+	}
+	return forms, true
+}
+
+// LoadPO loads messages from a gettext .po file. The msgid is used as key,
+// prefixed by the msgctxt and a '.' when there is one, so 'msgctxt "inbox"'
+// and 'msgid "title"' is the "inbox.title" message
+func (c *Catalog) LoadPO(contents []byte) error {
+	var msgctxt, msgid *string
+	var msgstrs map[int]*string
+	var last *string
+
+	flush := func() {
+		if msgid != nil && *msgid != "" && msgstrs[0] != nil && *msgstrs[0] != "" {
+			forms := make([]string, len(msgstrs))
+			for i, msgstr := range msgstrs {
+				if i >= 0 && i < len(forms) {
+					forms[i] = *msgstr
+				}
+			}
+			key := *msgid
+			if msgctxt != nil && *msgctxt != "" {
+				key = *msgctxt + "." + key
+			}
+			c.Set(key, forms...)
+		}
+		msgctxt, msgid, msgstrs, last = nil, nil, nil, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		keyword, quoted := "", line
+		if line[0] != '"' {
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("I18n: invalid line %d in %s catalog", lineNumber, c.Locale)
+			}
+			keyword, quoted = parts[0], strings.TrimSpace(parts[1])
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return fmt.Errorf("I18n: invalid string on line %d in %s catalog", lineNumber, c.Locale)
+		}
+
+		switch {
+		case keyword == "":
+			// Continuation of the previous string
+			if last == nil {
+				return fmt.Errorf("I18n: unexpected string on line %d in %s catalog", lineNumber, c.Locale)
+			}
+			*last += value
+		case keyword == "msgctxt":
+			flush()
+			msgctxt = &value
+			last = msgctxt
+		case keyword == "msgid":
+			if msgstrs != nil {
+				flush()
+			}
+			msgid = &value
+			last = msgid
+		case keyword == "msgid_plural":
+			// The msgid is the key, plural forms are in msgstr[n]
+			last = new(string)
+		case keyword == "msgstr" || strings.HasPrefix(keyword, "msgstr["):
+			index := 0
+			if keyword != "msgstr" {
+				index, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(keyword, "msgstr["), "]"))
+				if err != nil {
+					return fmt.Errorf("I18n: invalid msgstr index on line %d in %s catalog", lineNumber, c.Locale)
+				}
+			}
+			if msgstrs == nil {
+				msgstrs = map[int]*string{}
+			}
+			msgstrs[index] = &value
+			last = &value
+		default:
+			return fmt.Errorf("I18n: unknown keyword '%s' on line %d in %s catalog", keyword, lineNumber, c.Locale)
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+// I18n holds the message catalogs of each locale and translates messages.
+// When LocaleFromPath is set the first segment of request paths can pick
+// the locale, e.g. '/fr/about'
+type I18n struct {
+	DefaultLocale  string
+	LocaleFromPath bool
+	catalogs       map[string]*Catalog
+}
+
+// NewI18n creates an I18n instance without any catalogs
+func NewI18n(defaultLocale string) *I18n {
+	return &I18n{DefaultLocale: defaultLocale, catalogs: map[string]*Catalog{}}
+}
+
+// Catalog returns the catalog of a locale, creating it if needed
+func (i *I18n) Catalog(locale string) *Catalog {
+	if _, ok := i.catalogs[locale]; !ok {
+		i.catalogs[locale] = NewCatalog(locale)
+	}
+	return i.catalogs[locale]
+}
+
+// LoadDir loads the '<locale>.json' and '<locale>.po' catalogs in `dir`. A
+// missing directory is not an error
+func (i *I18n) LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		extension := filepath.Ext(file.Name())
+		if file.IsDir() || (extension != ".json" && extension != ".po") {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		catalog := i.Catalog(strings.TrimSuffix(file.Name(), extension))
+		if extension == ".json" {
+			err = catalog.LoadJSON(contents)
+		} else {
+			err = catalog.LoadPO(contents)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file.Name(), err.Error())
+		}
+	}
+	return nil
+}
+
+// Locales returns the locales having a catalog
+func (i *I18n) Locales() []string {
+	locales := []string{}
+	for locale := range i.catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Supported returns the locale having a catalog matching `locale`, either
+// exactly or by language, or ""
+func (i *I18n) Supported(locale string) string {
+	if locale == "" {
+		return ""
+	}
+	locale = strings.Replace(locale, "_", "-", -1)
+	locales := i.Locales()
+	for _, supported := range locales {
+		if strings.EqualFold(supported, locale) {
+			return supported
+		}
+	}
+	// Sorted so "fr" is picked over "fr-CA" for "fr-FR", and always the same
+	language := localeLanguage(locale)
+	for _, supported := range locales {
+		if strings.EqualFold(localeLanguage(supported), language) {
+			return supported
+		}
+	}
+	return ""
+}
+
+// Negotiate picks the supported locale best matching an Accept-Language
+// header, or "" if none match
+func (i *I18n) Negotiate(acceptLanguage string) string {
+	type languageQuality struct {
+		locale  string
+		quality float64
+	}
+	languages := []languageQuality{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		language := languageQuality{locale: strings.TrimSpace(fields[0]), quality: 1}
+		for _, param := range fields[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				language.quality, _ = strconv.ParseFloat(q[2:], 64)
+			}
+		}
+		if language.locale != "" && language.locale != "*" && language.quality > 0 {
+			languages = append(languages, language)
+		}
+	}
+	sort.SliceStable(languages, func(a, b int) bool {
+		return languages[a].quality > languages[b].quality
+	})
+
+	for _, language := range languages {
+		if supported := i.Supported(language.locale); supported != "" {
+			return supported
+		}
+	}
+	return ""
+}
+
+// lookup finds the forms of a message in the locale's catalog, falling back
+// to the default locale's
+func (i *I18n) lookup(locale, key string) (*Catalog, []string) {
+	for _, l := range []string{locale, i.Supported(locale), i.DefaultLocale} {
+		if catalog, ok := i.catalogs[l]; ok {
+			if forms, ok := catalog.messages[key]; ok && len(forms) > 0 {
+				return catalog, forms
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Has tells if a message exists for the locale or the default locale
+func (i *I18n) Has(locale, key string) bool {
+	_, forms := i.lookup(locale, key)
+	return forms != nil
+}
+
+// Translate returns the message for `key` in the given locale with
+// '{name}' placeholders replaced by `params`. The "count" param picks the
+// plural form. Missing messages are returned as their key
+func (i *I18n) Translate(locale, key string, params J) string {
+	catalog, forms := i.lookup(locale, key)
+	if forms == nil {
+		return interpolate(key, params)
+	}
+
+	message := forms[0]
+	if count, ok := params["count"]; ok && len(forms) > 1 {
+		n, _ := strconv.Atoi(fmt.Sprint(count))
+		if form := catalog.rule.Form(n); form < len(forms) && forms[form] != "" {
+			message = forms[form]
+		} else {
+			message = forms[len(forms)-1]
+		}
+	}
+	return interpolate(message, params)
+}
+
+func interpolate(message string, params J) string {
+	if len(params) == 0 || !strings.Contains(message, "{") {
+		return message
+	}
+	pairs := []string{}
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(message)
+}
+
+// tFn is what the 't' template function calls, it's set in the request data
+// by the i18n middleware
+type tFn func(key string, params ...interface{}) string
+
+// pairsToParams turns `"name", value, ...` pairs into params
+func pairsToParams(pairs []interface{}) J {
+	params := J{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		params[fmt.Sprint(pairs[i])] = pairs[i+1]
+	}
+	return params
+}
+
+// Locale returns the request's locale. It's taken from the 'locale' URL
+// variable, the first segment of the URL's path (with the 'localeFromPath'
+// config), the session, the Accept-Language header, then the 'locale'
+// config, in that order
+func (ctx *Context) Locale() string {
+	if ctx.locale != "" {
+		return ctx.locale
+	}
+	i18n := ctx.app.I18n
+	ctx.locale = i18n.DefaultLocale
+	if ctx.Request == nil {
+		return ctx.locale
+	}
+
+	candidates := []string{}
+	if vars, ok := ctx.Data["vars"].(map[string]string); ok {
+		candidates = append(candidates, vars["locale"])
+	}
+	if i18n.LocaleFromPath {
+		candidates = append(candidates, strings.SplitN(strings.TrimPrefix(ctx.Request.URL.Path, "/"), "/", 2)[0])
+	}
+	candidates = append(candidates, ctx.Session.Get("locale"))
+	candidates = append(candidates, i18n.Negotiate(ctx.Request.Header.Get("Accept-Language")))
+	for _, candidate := range candidates {
+		if supported := i18n.Supported(candidate); supported != "" {
+			ctx.locale = supported
+			break
+		}
+	}
+	return ctx.locale
+}
+
+// SetLocale sets the request's locale and remembers it in the session
+func (ctx *Context) SetLocale(locale string) {
+	ctx.locale = locale
+	ctx.Session.Set("locale", locale)
+}
+
+// T translates a message in the request's locale. Params are given as
+// name/value pairs, `ctx.T("inbox.messages", "count", 3)`
+func (ctx *Context) T(key string, params ...interface{}) string {
+	return ctx.app.I18n.Translate(ctx.Locale(), key, pairsToParams(params))
+}
+
+// translate is the 't' template function,
+// `{{ t . "inbox.messages" "count" .count }}`
+func translate(data interface{}, key string, params ...interface{}) string {
+	var fn tFn
+	switch values := data.(type) {
+	case J:
+		fn, _ = values["t"].(tFn)
+	case map[string]interface{}:
+		fn, _ = values["t"].(tFn)
+	}
+	if fn == nil {
+		return interpolate(key, pairsToParams(params))
+	}
+	return fn(key, params...)
+}
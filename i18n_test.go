@@ -0,0 +1,62 @@
+package weeb
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestI18nSupportedLanguageFallbackIsStable(t *testing.T) {
+	i18n := NewI18n("en")
+	for _, locale := range []string{"fr-CA", "fr", "fr-BE", "en"} {
+		i18n.Catalog(locale)
+	}
+	for i := 0; i < 20; i++ {
+		if supported := i18n.Supported("fr-FR"); supported != "fr" {
+			t.Fatalf("expected fr, got %q", supported)
+		}
+	}
+	if supported := i18n.Supported("fr_ca"); supported != "fr-CA" {
+		t.Fatalf("expected fr-CA, got %q", supported)
+	}
+}
+
+func TestLoadPOContext(t *testing.T) {
+	catalog := NewCatalog("fr")
+	err := catalog.LoadPO([]byte(`
+msgctxt "inbox"
+msgid "title"
+msgstr "Boîte de réception"
+
+msgid "title"
+msgstr "Titre"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forms := catalog.messages["inbox.title"]; len(forms) != 1 || forms[0] != "Boîte de réception" {
+		t.Fatalf("expected the msgctxt to prefix the key, got %v", forms)
+	}
+	if forms := catalog.messages["title"]; len(forms) != 1 || forms[0] != "Titre" {
+		t.Fatalf("expected the message without context to be kept, got %v", forms)
+	}
+}
+
+func TestLocaleFromPathIsOptIn(t *testing.T) {
+	for _, fromPath := range []bool{false, true} {
+		app := newTestApp(t, nil)
+		app.I18n.Catalog("fr")
+		app.I18n.LocaleFromPath = fromPath
+		app.Router.Get("/{page}/", func(ctx *Context) error {
+			return ctx.Text(200, ctx.Locale())
+		})
+
+		w := serve(app, httptest.NewRequest("GET", "/fr/", nil))
+		expected := "en"
+		if fromPath {
+			expected = "fr"
+		}
+		if w.Body.String() != expected {
+			t.Fatalf("expected %s with LocaleFromPath %v, got %q", expected, fromPath, w.Body.String())
+		}
+	}
+}
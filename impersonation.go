@@ -0,0 +1,65 @@
+package weeb
+
+import (
+	"errors"
+)
+
+var ErrorImpersonationForbidden = errors.New("User is not allowed to impersonate other users")
+var ErrorNotImpersonating = errors.New("User is not impersonating anyone")
+
+// Impersonate signs in as `target` while remembering the current user, who
+// needs the 'impersonatorRole' role ("admin" by default), so they can go back
+// to their own account with StopImpersonating
+func (a *Auth) Impersonate(ctx *Context, target AuthUser) error {
+	impersonator, err := a.CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+	if impersonator == nil || !containsString(impersonator.AuthRoles(), ctx.Config.Get("impersonatorRole", "admin")) {
+		ctx.Log.Warning("impersonation denied", L{"targetUserID": target.AuthID()})
+		return ErrorImpersonationForbidden
+	}
+	// Impersonating while impersonating keeps the original impersonator
+	if ctx.Session.Get("impersonatorID") == "" {
+		ctx.Session.Set("impersonatorID", impersonator.AuthID())
+	}
+
+	a.SigninUser(ctx, target)
+	ctx.Set("isImpersonating", true)
+	ctx.Log.Info("impersonation started", L{
+		"impersonatorID": ctx.Session.Get("impersonatorID"), "userID": target.AuthID(),
+	})
+	return nil
+}
+
+// StopImpersonating signs back in as the user that started impersonating
+func (a *Auth) StopImpersonating(ctx *Context) error {
+	impersonatorID := ctx.Session.Get("impersonatorID")
+	if impersonatorID == "" {
+		return ErrorNotImpersonating
+	}
+	impersonator, err := a.FindByID(ctx, impersonatorID)
+	if err != nil {
+		return err
+	}
+
+	userID := ctx.Session.Get("userID")
+	ctx.Session.Delete("impersonatorID")
+	ctx.Set("isImpersonating", false)
+	if impersonator == nil {
+		a.Signout(ctx)
+	} else {
+		a.SigninUser(ctx, impersonator)
+	}
+	ctx.Log.Info("impersonation stopped", L{"impersonatorID": impersonatorID, "userID": userID})
+	return nil
+}
+
+// Impersonator returns the user impersonating the current user, if any
+func (a *Auth) Impersonator(ctx *Context) (AuthUser, error) {
+	impersonatorID := ctx.Session.Get("impersonatorID")
+	if impersonatorID == "" {
+		return nil, nil
+	}
+	return a.FindByID(ctx, impersonatorID)
+}
@@ -0,0 +1,280 @@
+package weeb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusFailed  = "failed"
+)
+
+// JobFn represents a background job's implementation function
+type JobFn func(ctx *Context, job *Job) error
+
+// Job represents a job stored in the 'jobs' table
+type Job struct {
+	ID          int64
+	Name        string
+	Payload     string
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAt       time.Time
+	LockedAt    *time.Time
+	Created     time.Time
+	Updated     time.Time
+}
+
+// Bind decodes the job's JSON payload into the given value
+func (j *Job) Bind(value interface{}) error {
+	return json.Unmarshal([]byte(j.Payload), value)
+}
+
+// Jobs represents the background jobs subsystem. Handlers enqueue jobs and
+// the 'worker' task runs them
+type Jobs struct {
+	app      *App
+	handlers map[string]JobFn
+}
+
+// NewJobs creates a new Jobs instance
+func NewJobs(app *App) *Jobs {
+	return &Jobs{app: app, handlers: map[string]JobFn{}}
+}
+
+// Register registers a given job function under a given name
+func (j *Jobs) Register(name string, fn JobFn) {
+	j.handlers[name] = fn
+}
+
+// Enqueue adds a job to the queue, to be run as soon as a worker is available
+func (j *Jobs) Enqueue(name string, payload interface{}) error {
+	return j.EnqueueAt(name, payload, time.Now())
+}
+
+// EnqueueIn adds a job to the queue, to be run after the given delay
+func (j *Jobs) EnqueueIn(name string, payload interface{}, delay time.Duration) error {
+	return j.EnqueueAt(name, payload, time.Now().Add(delay))
+}
+
+// EnqueueAt adds a job to the queue, to be run at the given time
+func (j *Jobs) EnqueueAt(name string, payload interface{}, runAt time.Time) error {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	maxAttempts := j.app.Config.GetInt("jobsMaxAttempts", 10)
+	return j.app.DB.Exec(`
+		INSERT INTO jobs (id, name, payload, status, attempts, max_attempts, last_error, run_at, created, updated)
+		VALUES ($1, $2, $3, $4, 0, $5, '', $6, NOW(), NOW())
+	`, j.app.ID.Next(), name, string(bytes), JobStatusPending, maxAttempts, runAt)
+}
+
+// RunNext locks the next due job, runs it and records the outcome. It returns
+// false when there was no job ready to be run
+func (j *Jobs) RunNext() (bool, error) {
+	lockTimeout := j.app.Config.GetDuration("jobsLockTimeout", 15*time.Minute)
+	job := &Job{}
+	err := j.app.DB.QueryOne(job, `
+		UPDATE jobs SET status = $1, locked_at = NOW(), attempts = attempts + 1, updated = NOW()
+		WHERE id = (
+		  SELECT id FROM jobs
+		  WHERE (status = $2 AND run_at <= NOW())
+		     OR (status = $1 AND locked_at < NOW() - $3 * INTERVAL '1 second')
+		  ORDER BY run_at
+		  LIMIT 1
+		  FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, name, payload, status, attempts, max_attempts, last_error, run_at, locked_at, created, updated
+	`, JobStatusRunning, JobStatusPending, int64(lockTimeout/time.Second))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	log := j.app.Log.WithContext(L{"job": job.Name, "jobID": job.ID, "attempt": job.Attempts})
+	if err := j.run(job, log); err != nil {
+		log.Error("job failed", L{"err": err.Error(), "ms": int64(time.Since(start) / time.Millisecond)})
+		return true, j.fail(job, err)
+	}
+	log.Info("job done", L{"ms": int64(time.Since(start) / time.Millisecond)})
+	return true, j.app.DB.Exec(`DELETE FROM jobs WHERE id = $1`, job.ID)
+}
+
+func (j *Jobs) run(job *Job, log *Logger) (err error) {
+	fn, ok := j.handlers[job.Name]
+	if !ok {
+		return fmt.Errorf("no job registered with name '%s'", job.Name)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	ctx := NewContext(j.app)
+	ctx.Log = log
+	return fn(ctx, job)
+}
+
+func (j *Jobs) fail(job *Job, jobErr error) error {
+	if job.Attempts >= job.MaxAttempts {
+		return j.app.DB.Exec(`
+			UPDATE jobs SET status = $1, last_error = $2, locked_at = NULL, updated = NOW() WHERE id = $3
+		`, JobStatusFailed, jobErr.Error(), job.ID)
+	}
+	runAt := time.Now().Add(j.backoff(job.Attempts))
+	return j.app.DB.Exec(`
+		UPDATE jobs SET status = $1, last_error = $2, locked_at = NULL, run_at = $3, updated = NOW() WHERE id = $4
+	`, JobStatusPending, jobErr.Error(), runAt, job.ID)
+}
+
+// backoff returns the delay before retrying a job that failed `attempts`
+// times. It doubles at each attempt starting from 'jobsBackoff' up to 1 hour
+func (j *Jobs) backoff(attempts int) time.Duration {
+	delay := j.app.Config.GetDuration("jobsBackoff", 10*time.Second)
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// Work runs jobs until the `stop` channel is closed, polling the queue every
+// 'jobsPollInterval' when it is empty
+func (j *Jobs) Work(stop <-chan struct{}) {
+	interval := j.app.Config.GetDuration("jobsPollInterval", time.Second)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		ran, err := j.RunNext()
+		if err != nil {
+			j.app.Log.Error("error running job", L{"err": err.Error()})
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Failed returns the most recent jobs that exhausted all their attempts
+func (j *Jobs) Failed(limit int) ([]*Job, error) {
+	jobs := []*Job{}
+	err := j.app.DB.QueryAll(&jobs, `
+		SELECT id, name, payload, status, attempts, max_attempts, last_error, run_at, locked_at, created, updated
+		FROM jobs WHERE status = $1 ORDER BY updated DESC LIMIT $2
+	`, JobStatusFailed, limit)
+	return jobs, err
+}
+
+// Retry puts a failed job back in the queue with a fresh set of attempts
+func (j *Jobs) Retry(id int64) error {
+	return j.app.DB.Exec(`
+		UPDATE jobs SET status = $1, attempts = 0, run_at = NOW(), updated = NOW() WHERE id = $2 AND status = $3
+	`, JobStatusPending, id, JobStatusFailed)
+}
+
+// Tasks
+
+func jobsWorkerTask(app *App, args []string) error {
+	if err := app.connectDatabase(context.Background()); err != nil {
+		return err
+	}
+	concurrency := app.Config.GetInt("jobsConcurrency", 1)
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+
+	app.Log.Info("worker started", L{"concurrency": concurrency})
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.Jobs.Work(stop)
+		}()
+	}
+
+	// Wait for Ctrl-C / SIGINT or SIGTERM (sent by deploys and container
+	// stops) then let running jobs finish
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	app.Log.Info("worker shutting down", L{"signal": sig.String()})
+	close(stop)
+	wg.Wait()
+	return nil
+}
+
+func jobsTask(app *App, args []string) error {
+	if len(args) == 0 || args[0] == "help" {
+		return jobsTaskHelp(app)
+	} else if args[0] == "failed" {
+		return jobsTaskFailed(app)
+	} else if args[0] == "retry" {
+		return jobsTaskRetry(app, args[1:])
+	}
+	fmt.Printf("Error: unknown sub-task '%s' for task 'jobs'\n\n", args[0])
+	return nil
+}
+
+func jobsTaskHelp(app *App) error {
+	fmt.Println("'jobs' task usage:")
+	fmt.Println()
+	fmt.Println("    failed      shows the last 50 failed jobs and their last error")
+	fmt.Println("    retry <id>  puts a failed job back in the queue")
+	fmt.Println()
+	return nil
+}
+
+func jobsTaskFailed(app *App) error {
+	jobs, err := app.Jobs.Failed(50)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	for _, job := range jobs {
+		fmt.Printf("    %d  %s  %s  (%d attempts)\n", job.ID, job.Updated.Format("2006-01-02 15:04:05"), job.Name, job.Attempts)
+		fmt.Printf("        %s\n", job.LastError)
+	}
+	fmt.Println()
+	return nil
+}
+
+func jobsTaskRetry(app *App, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing job id")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	return app.Jobs.Retry(id)
+}
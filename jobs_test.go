@@ -0,0 +1,97 @@
+package weeb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobsBackoff(t *testing.T) {
+	app := newTestApp(t, map[string]string{"APP_JOBS_BACKOFF": "10s"})
+	expected := map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		9:  2560 * time.Second,
+		10: time.Hour,
+		50: time.Hour,
+	}
+	for attempts, delay := range expected {
+		if backoff := app.Jobs.backoff(attempts); backoff != delay {
+			t.Fatalf("expected %s after %d attempts, got %s", delay, attempts, backoff)
+		}
+	}
+}
+
+func TestJobsFail(t *testing.T) {
+	app := newTestApp(t, nil)
+	db := &recordingDB{}
+	app.DB = db
+
+	start := time.Now()
+	if err := app.Jobs.fail(&Job{ID: 1, Attempts: 2, MaxAttempts: 3}, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	retried := db.executed("UPDATE jobs")
+	if len(retried) != 1 || retried[0].args[0] != JobStatusPending || retried[0].args[1] != "boom" {
+		t.Fatalf("expected the job to be retried, got %v", retried)
+	}
+	if runAt := retried[0].args[2].(time.Time); runAt.Before(start.Add(20 * time.Second)) {
+		t.Fatalf("expected the retry to be delayed by the backoff, got %s", runAt)
+	}
+
+	if err := app.Jobs.fail(&Job{ID: 2, Attempts: 3, MaxAttempts: 3}, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	failed := db.executed("UPDATE jobs")[1]
+	if failed.args[0] != JobStatusFailed || failed.args[2] != int64(2) {
+		t.Fatalf("expected the job to be marked failed, got %v", failed)
+	}
+}
+
+func TestJobsRun(t *testing.T) {
+	app := newTestApp(t, nil)
+	log := app.Log.WithContext(L{})
+	app.Jobs.Register("panics", func(ctx *Context, job *Job) error {
+		panic("oops")
+	})
+	app.Jobs.Register("binds", func(ctx *Context, job *Job) error {
+		payload := map[string]int{}
+		if err := job.Bind(&payload); err != nil {
+			return err
+		}
+		if payload["n"] != 42 {
+			return errors.New("unexpected payload")
+		}
+		return nil
+	})
+
+	if err := app.Jobs.run(&Job{Name: "panics"}, log); err == nil || err.Error() != "panic: oops" {
+		t.Fatalf("expected the panic to be recovered as an error, got %v", err)
+	}
+	if err := app.Jobs.run(&Job{Name: "missing"}, log); err == nil || !strings.Contains(err.Error(), "no job registered") {
+		t.Fatalf("expected an unknown job error, got %v", err)
+	}
+	if err := app.Jobs.run(&Job{Name: "binds", Payload: `{"n": 42}`}, log); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobsRetry(t *testing.T) {
+	app := newTestApp(t, nil)
+	db := &recordingDB{}
+	app.DB = db
+
+	if err := app.Jobs.Retry(7); err != nil {
+		t.Fatal(err)
+	}
+	statements := db.executed("UPDATE jobs")
+	if len(statements) != 1 || !strings.Contains(statements[0].query, "attempts = 0") {
+		t.Fatalf("expected the attempts to be reset, got %v", statements)
+	}
+	args := statements[0].args
+	if args[0] != JobStatusPending || args[1] != int64(7) || args[2] != JobStatusFailed {
+		t.Fatalf("expected only failed job 7 to be put back, got %v", args)
+	}
+}
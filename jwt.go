@@ -0,0 +1,414 @@
+package weeb
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrorJWTMalformed = errors.New("Token is malformed")
+var ErrorJWTUnknownKey = errors.New("Token is signed with an unknown key")
+var ErrorJWTSignature = errors.New("Token signature is invalid")
+var ErrorJWTExpired = errors.New("Token is expired")
+var ErrorJWTNotYetValid = errors.New("Token is not valid yet")
+var ErrorJWTIssuer = errors.New("Token issuer is invalid")
+var ErrorJWTAudience = errors.New("Token audience is invalid")
+
+// jwtLock guards the lazy loading of Auth's JWT keys
+var jwtLock sync.Mutex
+
+type jwtKey struct {
+	id      string
+	secret  []byte
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// JWT signs and verifies JSON Web Tokens. It is configured with:
+//
+//	jwtAlg         HS256 (default), RS256 or EdDSA
+//	jwtSecret      HS256 secrets split on ',', the first one signs
+//	jwtPrivateKey  path to the PEM private key signing RS256/EdDSA tokens
+//	jwtPublicKeys  paths to extra PEM public keys accepted, split on ','
+//	jwtIssuer      'iss' claim set and checked, if set
+//	jwtAudience    'aud' claim set and checked, if set
+//	jwtTtl         lifetime of issued tokens (1h by default)
+//
+// Keys are identified with the 'kid' header which allows rotating them
+type JWT struct {
+	alg      string
+	keys     []*jwtKey
+	issuer   string
+	audience string
+	ttl      time.Duration
+	leeway   time.Duration
+}
+
+// NewJWT creates a JWT instance from the given config
+func NewJWT(config *Config) (*JWT, error) {
+	j := &JWT{
+		alg:      config.Get("jwtAlg", "HS256"),
+		issuer:   config.Get("jwtIssuer", ""),
+		audience: config.Get("jwtAudience", ""),
+		ttl:      config.GetDuration("jwtTtl", time.Hour),
+		leeway:   config.GetDuration("jwtLeeway", 30*time.Second),
+	}
+
+	switch j.alg {
+	case "HS256":
+		for _, secret := range strings.Split(config.Get("jwtSecret", ""), ",") {
+			if secret != "" {
+				j.keys = append(j.keys, &jwtKey{id: jwtKeyID([]byte(secret)), secret: []byte(secret)})
+			}
+		}
+	case "RS256", "EdDSA":
+		if path := config.Get("jwtPrivateKey", ""); path != "" {
+			key, err := loadJWTKey(path)
+			if err != nil {
+				return nil, err
+			}
+			if key.private == nil {
+				return nil, fmt.Errorf("JWT: '%s' is not a private key", path)
+			}
+			j.keys = append(j.keys, key)
+		}
+		for _, path := range strings.Split(config.Get("jwtPublicKeys", ""), ",") {
+			if path == "" {
+				continue
+			}
+			key, err := loadJWTKey(path)
+			if err != nil {
+				return nil, err
+			}
+			j.keys = append(j.keys, key)
+		}
+	default:
+		return nil, fmt.Errorf("JWT: unsupported algorithm '%s'", j.alg)
+	}
+
+	if len(j.keys) == 0 {
+		return nil, errors.New("JWT: no keys configured, set 'jwtSecret' or 'jwtPrivateKey'")
+	}
+	for _, key := range j.keys {
+		if !j.keyMatchesAlg(key) {
+			return nil, fmt.Errorf("JWT: key '%s' can't be used with '%s'", key.id, j.alg)
+		}
+	}
+	return j, nil
+}
+
+func (j *JWT) keyMatchesAlg(key *jwtKey) bool {
+	switch j.alg {
+	case "HS256":
+		return key.secret != nil
+	case "RS256":
+		_, ok := key.public.(*rsa.PublicKey)
+		return ok
+	case "EdDSA":
+		_, ok := key.public.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+func loadJWTKey(path string) (*jwtKey, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("JWT: no PEM data found in '%s'", path)
+	}
+
+	key := &jwtKey{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("JWT: unsupported private key type in '%s'", path)
+		}
+		key.private = signer
+	case "PUBLIC KEY":
+		if key.public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case "RSA PUBLIC KEY":
+		if key.public, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("JWT: unsupported PEM block '%s' in '%s'", block.Type, path)
+	}
+
+	if key.private != nil {
+		key.public = key.private.Public()
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		return nil, err
+	}
+	key.id = jwtKeyID(der)
+	return key, nil
+}
+
+func jwtKeyID(material []byte) string {
+	hash := sha256.Sum256(material)
+	return hex.EncodeToString(hash[:8])
+}
+
+// Sign signs the given claims, adding 'iat', 'exp', 'iss' and 'aud' claims
+// unless they are already present
+func (j *JWT) Sign(claims J) (string, error) {
+	key := j.keys[0]
+	if key.secret == nil && key.private == nil {
+		return "", errors.New("JWT: no private key configured to sign tokens")
+	}
+
+	now := time.Now()
+	payload := J{"iat": now.Unix(), "exp": now.Add(j.ttl).Unix()}
+	if j.issuer != "" {
+		payload["iss"] = j.issuer
+	}
+	if j.audience != "" {
+		payload["aud"] = j.audience
+	}
+	for k, v := range claims {
+		payload[k] = v
+	}
+
+	header, err := json.Marshal(J{"alg": j.alg, "typ": "JWT", "kid": key.id})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtEncode(header) + "." + jwtEncode(body)
+
+	var signature []byte
+	switch j.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		hash := sha256.Sum256([]byte(signingInput))
+		signature, err = key.private.Sign(rand.Reader, hash[:], crypto.SHA256)
+	case "EdDSA":
+		signature, err = key.private.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	}
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwtEncode(signature), nil
+}
+
+// Verify checks the token's signature, expiry, issuer and audience and
+// returns it's claims
+func (j *JWT) Verify(token string) (J, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrorJWTMalformed
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := jwtDecode(parts[0], &header); err != nil {
+		return nil, ErrorJWTMalformed
+	}
+	// Never trust the token to choose the algorithm
+	if header.Alg != j.alg {
+		return nil, ErrorJWTSignature
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrorJWTMalformed
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	verified := false
+	knownKey := false
+	for _, key := range j.keys {
+		if header.Kid != "" && header.Kid != key.id {
+			continue
+		}
+		knownKey = true
+		if j.verifySignature(key, signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !knownKey {
+		return nil, ErrorJWTUnknownKey
+	}
+	if !verified {
+		return nil, ErrorJWTSignature
+	}
+
+	claims := J{}
+	if err := jwtDecode(parts[1], &claims); err != nil {
+		return nil, ErrorJWTMalformed
+	}
+	return claims, j.checkClaims(claims)
+}
+
+func (j *JWT) verifySignature(key *jwtKey, signingInput string, signature []byte) bool {
+	switch j.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(signature, mac.Sum(nil))
+	case "RS256":
+		hash := sha256.Sum256([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(key.public.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	case "EdDSA":
+		return ed25519.Verify(key.public.(ed25519.PublicKey), []byte(signingInput), signature)
+	}
+	return false
+}
+
+func (j *JWT) checkClaims(claims J) error {
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(j.leeway)) {
+		return ErrorJWTExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return ErrorJWTNotYetValid
+	}
+	if j.issuer != "" && claims["iss"] != j.issuer {
+		return ErrorJWTIssuer
+	}
+	if j.audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud != j.audience {
+				return ErrorJWTAudience
+			}
+		case []interface{}:
+			found := false
+			for _, a := range aud {
+				found = found || a == j.audience
+			}
+			if !found {
+				return ErrorJWTAudience
+			}
+		default:
+			return ErrorJWTAudience
+		}
+	}
+	return nil
+}
+
+func jwtEncode(bytes []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func jwtDecode(part string, value interface{}) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, value)
+}
+
+// isJWT tells JWTs apart from API tokens
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// JWT returns Auth's JWT instance, loading keys from config the first time
+func (a *Auth) JWT() (*JWT, error) {
+	jwtLock.Lock()
+	defer jwtLock.Unlock()
+	if a.jwt == nil {
+		j, err := NewJWT(a.app.Config)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = j
+	}
+	return a.jwt, nil
+}
+
+// IssueJWT signs a token for the given user with 'sub' set to it's ID
+func (a *Auth) IssueJWT(user AuthUser, claims J) (string, error) {
+	j, err := a.JWT()
+	if err != nil {
+		return "", err
+	}
+	payload := J{"sub": user.AuthID()}
+	for k, v := range claims {
+		payload[k] = v
+	}
+	return j.Sign(payload)
+}
+
+// JWTMiddleware authenticates requests bearing a JWT in their
+// 'Authorization: Bearer' header. The token's 'sub' claim is loaded through
+// FindByID as the current user. Invalid tokens get a 401 response
+func (a *Auth) JWTMiddleware(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		token := bearerToken(ctx)
+		if token == "" || !isJWT(token) {
+			return next(ctx)
+		}
+
+		j, err := a.JWT()
+		if err != nil {
+			return err
+		}
+		claims, err := j.Verify(token)
+		if err != nil {
+			return jwtUnauthorized(ctx, err.Error())
+		}
+		subject, _ := claims["sub"].(string)
+		user, err := a.FindByID(ctx, subject)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return jwtUnauthorized(ctx, "Token subject not found")
+		}
+
+		ctx.Set("currentUser", user)
+		ctx.Set("jwtClaims", claims)
+		setSignedIn(ctx, user)
+		return next(ctx)
+	}
+}
+
+func jwtUnauthorized(ctx *Context, message string) error {
+	ctx.Log.Warning("invalid jwt", L{"err": message})
+	ctx.SetHeader("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+message+`"`)
+	ctx.Set("authError", message)
+	return ctx.Error(401, message)
+}
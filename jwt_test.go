@@ -0,0 +1,228 @@
+package weeb
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestJWT creates a JWT from the given config values
+func newTestJWT(t *testing.T, values map[string]string) *JWT {
+	config := NewConfig()
+	config.LoadValues(values)
+	j, err := NewJWT(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// writeTestPEM writes a PEM block to a temporary file and returns it's path
+func writeTestPEM(t *testing.T, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testRSAKeys(t *testing.T) (string, string, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestPEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)), writeTestPEM(t, "PUBLIC KEY", public), key
+}
+
+func testEd25519Keys(t *testing.T) (string, string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestPEM(t, "PRIVATE KEY", privateDER), writeTestPEM(t, "PUBLIC KEY", publicDER)
+}
+
+// signTestJWT builds a token with the given header, signed with HS256
+func signTestJWT(header, claims J, secret []byte) string {
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := jwtEncode(headerJSON) + "." + jwtEncode(claimsJSON)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + jwtEncode(mac.Sum(nil))
+}
+
+func TestJWTSignAndVerify(t *testing.T) {
+	rsaPrivate, _, _ := testRSAKeys(t)
+	edPrivate, _ := testEd25519Keys(t)
+	for alg, values := range map[string]map[string]string{
+		"HS256": {"jwtSecret": "s3cret"},
+		"RS256": {"jwtAlg": "RS256", "jwtPrivateKey": rsaPrivate},
+		"EdDSA": {"jwtAlg": "EdDSA", "jwtPrivateKey": edPrivate},
+	} {
+		values["jwtIssuer"] = "weeb"
+		values["jwtAudience"] = "api"
+		j := newTestJWT(t, values)
+		token, err := j.Sign(J{"sub": "42"})
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		claims, err := j.Verify(token)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if claims["sub"] != "42" || claims["iss"] != "weeb" || claims["aud"] != "api" {
+			t.Fatalf("%s: unexpected claims %v", alg, claims)
+		}
+
+		parts := strings.Split(token, ".")
+		tampered, _ := json.Marshal(J{"sub": "1", "exp": time.Now().Add(time.Hour).Unix(), "iss": "weeb", "aud": "api"})
+		if _, err := j.Verify(parts[0] + "." + jwtEncode(tampered) + "." + parts[2]); err != ErrorJWTSignature {
+			t.Fatalf("%s: expected a tampered token to be refused, got %v", alg, err)
+		}
+	}
+}
+
+func TestJWTVerifyClaims(t *testing.T) {
+	j := newTestJWT(t, map[string]string{"jwtSecret": "s3cret", "jwtIssuer": "weeb", "jwtAudience": "api"})
+	now := time.Now()
+	for _, test := range []struct {
+		claims J
+		err    error
+	}{
+		{J{"exp": now.Add(-time.Hour).Unix()}, ErrorJWTExpired},
+		{J{"exp": nil}, ErrorJWTExpired},
+		{J{"nbf": now.Add(time.Hour).Unix()}, ErrorJWTNotYetValid},
+		{J{"iss": "other"}, ErrorJWTIssuer},
+		{J{"aud": "other"}, ErrorJWTAudience},
+		{J{"aud": []string{"other", "api"}}, nil},
+		// Within the leeway
+		{J{"exp": now.Add(-10 * time.Second).Unix(), "nbf": now.Add(10 * time.Second).Unix()}, nil},
+	} {
+		token, err := j.Sign(test.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := j.Verify(token); err != test.err {
+			t.Fatalf("expected %v for %v, got %v", test.err, test.claims, err)
+		}
+	}
+}
+
+func TestJWTVerifyRefusesOtherAlgorithms(t *testing.T) {
+	rsaPrivate, _, rsaKey := testRSAKeys(t)
+	j := newTestJWT(t, map[string]string{"jwtAlg": "RS256", "jwtPrivateKey": rsaPrivate})
+	claims := J{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()}
+
+	// alg=none with an empty signature
+	headerJSON, _ := json.Marshal(J{"alg": "none", "typ": "JWT"})
+	claimsJSON, _ := json.Marshal(claims)
+	if _, err := j.Verify(jwtEncode(headerJSON) + "." + jwtEncode(claimsJSON) + "."); err != ErrorJWTSignature {
+		t.Fatalf("expected alg=none to be refused, got %v", err)
+	}
+
+	// HS256 signed with the RSA public key, the classic algorithm confusion
+	public, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+	token := signTestJWT(J{"alg": "HS256", "typ": "JWT", "kid": jwtKeyID(public)}, claims, publicPEM)
+	if _, err := j.Verify(token); err != ErrorJWTSignature {
+		t.Fatalf("expected an HS256 token to be refused, got %v", err)
+	}
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.", jwtEncode([]byte("{")) + ".e30."} {
+		if _, err := j.Verify(token); err != ErrorJWTMalformed {
+			t.Fatalf("expected '%s' to be malformed, got %v", token, err)
+		}
+	}
+}
+
+func TestJWTVerifyRefusesUnknownKeys(t *testing.T) {
+	j := newTestJWT(t, map[string]string{"jwtSecret": "s3cret"})
+	claims := J{"exp": time.Now().Add(time.Hour).Unix()}
+
+	other := newTestJWT(t, map[string]string{"jwtSecret": "other"})
+	token, _ := other.Sign(claims)
+	if _, err := j.Verify(token); err != ErrorJWTUnknownKey {
+		t.Fatalf("expected a token from another key to be refused, got %v", err)
+	}
+
+	// A kid matching the key doesn't help a token signed with another secret
+	token = signTestJWT(J{"alg": "HS256", "kid": jwtKeyID([]byte("s3cret"))}, claims, []byte("other"))
+	if _, err := j.Verify(token); err != ErrorJWTSignature {
+		t.Fatalf("expected a wrong signature to be refused, got %v", err)
+	}
+
+	// Tokens without a kid are checked against every key
+	token = signTestJWT(J{"alg": "HS256"}, claims, []byte("s3cret"))
+	if _, err := j.Verify(token); err != nil {
+		t.Fatalf("expected a token without kid to verify, got %v", err)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldJWT := newTestJWT(t, map[string]string{"jwtSecret": "old"})
+	oldToken, _ := oldJWT.Sign(J{"sub": "42"})
+
+	rotated := newTestJWT(t, map[string]string{"jwtSecret": "new,old"})
+	if _, err := rotated.Verify(oldToken); err != nil {
+		t.Fatalf("expected tokens signed with the old secret to verify, got %v", err)
+	}
+	newToken, _ := rotated.Sign(J{"sub": "42"})
+	if _, err := oldJWT.Verify(newToken); err != ErrorJWTUnknownKey {
+		t.Fatalf("expected new tokens to be signed with the new secret, got %v", err)
+	}
+
+	// Asymmetric keys rotate by keeping the old public key around
+	oldPrivate, oldPublic, _ := testRSAKeys(t)
+	newPrivate, _, _ := testRSAKeys(t)
+	oldToken, _ = newTestJWT(t, map[string]string{"jwtAlg": "RS256", "jwtPrivateKey": oldPrivate}).Sign(J{"sub": "42"})
+	rotated = newTestJWT(t, map[string]string{"jwtAlg": "RS256", "jwtPrivateKey": newPrivate, "jwtPublicKeys": oldPublic})
+	if _, err := rotated.Verify(oldToken); err != nil {
+		t.Fatalf("expected tokens signed with the old private key to verify, got %v", err)
+	}
+	withoutOld := newTestJWT(t, map[string]string{"jwtAlg": "RS256", "jwtPrivateKey": newPrivate})
+	if _, err := withoutOld.Verify(oldToken); err != ErrorJWTUnknownKey {
+		t.Fatalf("expected retired keys to be refused, got %v", err)
+	}
+}
+
+func TestNewJWTRefusesMismatchedKeys(t *testing.T) {
+	_, edPublic := testEd25519Keys(t)
+	rsaPrivate, _, _ := testRSAKeys(t)
+	for _, values := range []map[string]string{
+		{},
+		{"jwtAlg": "none", "jwtSecret": "s3cret"},
+		{"jwtAlg": "RS256", "jwtPrivateKey": edPublic},
+		{"jwtAlg": "RS256", "jwtPrivateKey": rsaPrivate, "jwtPublicKeys": edPublic},
+		{"jwtAlg": "EdDSA", "jwtPrivateKey": rsaPrivate},
+	} {
+		config := NewConfig()
+		config.LoadValues(values)
+		if _, err := NewJWT(config); err == nil {
+			t.Fatalf("expected %v to be refused", values)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package weeb
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+type cachedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// CacheResponses returns a middleware caching whole responses to anonymous
+// GET and HEAD requests in App.Cache for `ttl`. Requests with an
+// 'Authorization' header are never cached. Cache keys are built from the
+// method, url, request locale and the values of the given `vary` request
+// headers. Handlers can opt out by setting a 'Cache-Control: no-store' or
+// 'private' header, which rendering a CSRF token does. Responses changing
+// the session aren't cached
+func CacheResponses(ttl time.Duration, vary ...string) func(HandlerFunc) HandlerFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) error {
+			method := ctx.Request.Method
+			if (method != "GET" && method != "HEAD") || ctx.Get("isSignedIn") == true ||
+				ctx.Request.Header.Get("Authorization") != "" {
+				return next(ctx)
+			}
+
+			key := responseCacheKey(ctx, vary)
+			cached := &cachedResponse{}
+			err := ctx.Cache.Get(key, cached)
+			if err == nil {
+				for name, values := range cached.Header {
+					ctx.Response.Header()[name] = values
+				}
+				ctx.SetHeader("X-Cache", "HIT")
+				ctx.SetStatusCode(cached.StatusCode)
+				ctx.SetBody(cached.Body)
+				return nil
+			}
+			if err != CacheKeyNotFoundError {
+				ctx.Log.Error("error reading response cache", L{"err": err.Error()})
+			}
+
+			ctx.SetHeader("X-Cache", "MISS")
+			if err := next(ctx); err != nil {
+				return err
+			}
+			// An empty body means the handler wrote the response itself (e.g. static files)
+			if ctx.StatusCode() != 200 || ctx.body == "" || ctx.Session.modified || !responseCacheable(ctx.Response.Header()) {
+				return nil
+			}
+
+			cached = &cachedResponse{StatusCode: ctx.StatusCode(), Header: http.Header{}, Body: ctx.body}
+			for name, values := range ctx.Response.Header() {
+				if name != "Set-Cookie" && name != "X-Cache" {
+					cached.Header[name] = values
+				}
+			}
+			if err := ctx.Cache.Set(key, cached, ttl); err != nil {
+				ctx.Log.Error("error writing response cache", L{"err": err.Error()})
+			}
+			return nil
+		}
+	}
+}
+
+func responseCacheKey(ctx *Context, vary []string) string {
+	r := ctx.Request
+	// The locale can come from the session or Accept-Language, not the url
+	key := "response:" + r.Method + " " + r.Host + r.URL.RequestURI() + "|" + ctx.Locale()
+	for _, name := range vary {
+		key += "|" + r.Header.Get(name)
+	}
+	return key
+}
+
+func responseCacheable(header http.Header) bool {
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
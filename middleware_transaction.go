@@ -0,0 +1,36 @@
+package weeb
+
+import (
+	"errors"
+)
+
+var errorTransactionRollback = errors.New("transaction rolled back")
+
+// Transaction is a middleware running the whole request in a database
+// transaction. Handlers get the transaction as ctx.DB and ctx.DBHelper, it's
+// rolled back when they return an error or respond with a 5xx status code.
+// Use it on the router or group serving the routes so the response is only
+// sent once the transaction is committed
+func Transaction(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		db, dbHelper := ctx.DB, ctx.DBHelper
+		defer func() {
+			ctx.DB, ctx.DBHelper = db, dbHelper
+		}()
+
+		err := db.Tx(func(tx DB) error {
+			ctx.DB, ctx.DBHelper = tx, NewDBHelper(tx)
+			if err := next(ctx); err != nil {
+				return err
+			}
+			if ctx.StatusCode() >= 500 {
+				return errorTransactionRollback
+			}
+			return nil
+		})
+		if err == errorTransactionRollback {
+			return nil
+		}
+		return err
+	}
+}
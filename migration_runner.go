@@ -15,6 +15,10 @@ type Migration struct {
 	ID   string
 	Up   func(app *App) error
 	Down func(app *App) error
+	// NoTx runs the migration outside of a transaction, for statements like
+	// 'CREATE INDEX CONCURRENTLY' that can't run in one. A failing NoTx
+	// migration can leave partial changes behind
+	NoTx bool
 }
 
 // MigrationRunner represents an instance of a migration runner with it's
@@ -31,7 +35,17 @@ func NewMigrationRunner(app *App) *MigrationRunner {
 
 // Add adds a new migration definition to the MigrationRunner
 func (m *MigrationRunner) Add(id string, upFn, downFn func(app *App) error) {
-	m.migrations = append(m.migrations, &Migration{ID: id, Up: upFn, Down: downFn})
+	m.AddMigration(&Migration{ID: id, Up: upFn, Down: downFn})
+}
+
+// AddNoTx adds a new migration definition run outside of a transaction
+func (m *MigrationRunner) AddNoTx(id string, upFn, downFn func(app *App) error) {
+	m.AddMigration(&Migration{ID: id, Up: upFn, Down: downFn, NoTx: true})
+}
+
+// AddMigration adds the given migration to the MigrationRunner
+func (m *MigrationRunner) AddMigration(migration *Migration) {
+	m.migrations = append(m.migrations, migration)
 }
 
 // EnsureTable ensures the 'migrations' table exists in the database
@@ -45,40 +59,34 @@ func (m *MigrationRunner) EnsureTable() error {
 	`)
 }
 
-func (m *MigrationRunner) currentMigrationIndex() (int, error) {
+// appliedMigrationIDs returns the IDs of the migrations that were run up
+func (m *MigrationRunner) appliedMigrationIDs() (map[string]bool, error) {
 	if err := m.EnsureTable(); err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	lastMigrationID := ""
-	lastMigrationSQL := `SELECT id FROM migrations ORDER BY id DESC LIMIT 1`
-	err := m.app.DB.QueryOne(&lastMigrationID, lastMigrationSQL)
-	if err != nil && err != sql.ErrNoRows {
-		return 0, err
+	ids := []string{}
+	if err := m.app.DB.QueryAll(&ids, `SELECT id FROM migrations`); err != nil {
+		return nil, err
 	}
-
-	if lastMigrationID == "" {
-		return -1, nil
+	applied := map[string]bool{}
+	for _, id := range ids {
+		applied[id] = true
 	}
+	return applied, nil
+}
 
+func (m *MigrationRunner) sortMigrations() {
 	sort.Slice(m.migrations, func(i, j int) bool {
 		return m.migrations[i].ID < m.migrations[j].ID
 	})
-
-	currentIndex := 0
-	for i, m := range m.migrations {
-		if m.ID > lastMigrationID {
-			break
-		}
-		currentIndex = i
-	}
-
-	return currentIndex, nil
 }
 
-// RunUp runs the 'up' part of 'n' migrations from the last migration
+// RunUp runs the 'up' part of the first 'n' registered migrations that were
+// not run yet, in ID order. Migrations registered after others ran (e.g. when
+// enabling accounts) are run too. A negative 'n' runs all of them
 func (m *MigrationRunner) RunUp(n int) error {
-	currentMigrationIndex, err := m.currentMigrationIndex()
+	applied, err := m.appliedMigrationIDs()
 	if err != nil {
 		return err
 	}
@@ -88,31 +96,38 @@ func (m *MigrationRunner) RunUp(n int) error {
 		return nil
 	}
 
-	sort.Slice(m.migrations, func(i, j int) bool {
-		return m.migrations[i].ID < m.migrations[j].ID
-	})
+	m.sortMigrations()
 
 	fmt.Println()
 
-	targetIndex := len(m.migrations)
-	if n > 0 && currentMigrationIndex+n < len(m.migrations) {
-		targetIndex = currentMigrationIndex + n
-	}
-
-	var i int
-	for i = currentMigrationIndex + 1; i < targetIndex; i++ {
-		if err := m.migrations[i].Up(m.app); err != nil {
-			fmt.Printf("Error running up for migration '%s'\n\n%v\n\n", m.migrations[i].ID, err)
+	ran := 0
+	for _, migration := range m.migrations {
+		if applied[migration.ID] {
+			continue
+		}
+		if n > 0 && ran >= n {
+			break
+		}
+		var upErr error
+		err := m.run(migration, func(app *App) error {
+			if upErr = migration.Up(app); upErr != nil {
+				return upErr
+			}
+			insertMigrationSQL := `INSERT INTO migrations (id, created) VALUES ($1, NOW())`
+			return app.DB.Exec(insertMigrationSQL, migration.ID)
+		})
+		if upErr != nil {
+			fmt.Printf("Error running up for migration '%s'\n\n%v\n\n", migration.ID, upErr)
 			return nil
 		}
-		insertMigrationSQL := `INSERT INTO migrations (id, created) VALUES ($1, NOW())`
-		if err := m.app.DB.Exec(insertMigrationSQL, m.migrations[i].ID); err != nil {
+		if err != nil {
 			return err
 		}
-		fmt.Printf("Ran up for '%s'\n", m.migrations[i].ID)
+		fmt.Printf("Ran up for '%s'\n", migration.ID)
+		ran++
 	}
 
-	if i == currentMigrationIndex+1 {
+	if ran == 0 {
 		fmt.Println("Nothing to do")
 	}
 
@@ -121,10 +136,9 @@ func (m *MigrationRunner) RunUp(n int) error {
 	return nil
 }
 
-// RunDown runs the 'down' part of 'n' migrations from the last migration
+// RunDown runs the 'down' part of the last migration that was run up
 func (m *MigrationRunner) RunDown(n int) error {
-	currentMigrationIndex, err := m.currentMigrationIndex()
-	if err != nil {
+	if err := m.EnsureTable(); err != nil {
 		return err
 	}
 
@@ -133,27 +147,45 @@ func (m *MigrationRunner) RunDown(n int) error {
 		return nil
 	}
 
-	if currentMigrationIndex == -1 {
+	if n != 1 {
+		return errors.New("RunDown does not support an 'n' value other than '1'")
+	}
+
+	lastMigrationID := ""
+	lastMigrationSQL := `SELECT id FROM migrations ORDER BY created DESC, id DESC LIMIT 1`
+	err := m.app.DB.QueryOne(&lastMigrationID, lastMigrationSQL)
+	if err == sql.ErrNoRows {
 		fmt.Printf("\nNothing to do\n\n")
 		return nil
 	}
-
-	if n != 1 {
-		return errors.New("RunDown does not support an 'n' value other than '1'")
+	if err != nil {
+		return err
 	}
 
-	sort.Slice(m.migrations, func(i, j int) bool {
-		return m.migrations[i].ID < m.migrations[j].ID
-	})
+	var migration *Migration
+	for _, registered := range m.migrations {
+		if registered.ID == lastMigrationID {
+			migration = registered
+		}
+	}
+	if migration == nil {
+		return fmt.Errorf("last migration run '%s' is not registered", lastMigrationID)
+	}
 
 	fmt.Println()
-	migration := m.migrations[currentMigrationIndex]
-	if err := migration.Down(m.app); err != nil {
-		fmt.Printf("Error running down for migration '%s'\n\n%v\n\n", migration.ID, err)
+	var downErr error
+	err = m.run(migration, func(app *App) error {
+		if downErr = migration.Down(app); downErr != nil {
+			return downErr
+		}
+		deleteMigrationSQL := `DELETE FROM migrations WHERE id = $1`
+		return app.DB.Exec(deleteMigrationSQL, migration.ID)
+	})
+	if downErr != nil {
+		fmt.Printf("Error running down for migration '%s'\n\n%v\n\n", migration.ID, downErr)
 		return nil
 	}
-	deleteMigrationSQL := `DELETE FROM migrations WHERE id = $1`
-	if err := m.app.DB.Exec(deleteMigrationSQL, migration.ID); err != nil {
+	if err != nil {
 		return err
 	}
 	fmt.Printf("Ran down for '%s'\n", migration.ID)
@@ -162,6 +194,25 @@ func (m *MigrationRunner) RunDown(n int) error {
 	return nil
 }
 
+// run runs fn in a transaction unless the migration opted out with NoTx
+func (m *MigrationRunner) run(migration *Migration, fn func(app *App) error) error {
+	if migration.NoTx {
+		return fn(m.app)
+	}
+	return m.inTx(fn)
+}
+
+// inTx runs fn in a transaction, with a copy of the app using it as it's DB
+// so a failing migration doesn't leave partial changes behind
+func (m *MigrationRunner) inTx(fn func(app *App) error) error {
+	return m.app.DB.Tx(func(tx DB) error {
+		app := *m.app
+		app.DB = tx
+		app.DBHelper = NewDBHelper(tx)
+		return fn(&app)
+	})
+}
+
 // Tasks
 
 func migrationRunnerTask(app *App, args []string) error {
@@ -194,10 +245,8 @@ func migrationRunnerTaskHelp(app *App) error {
 }
 
 func migrationRunnerTaskList(app *App) error {
+	app.Migrations.sortMigrations()
 	migrations := app.Migrations.migrations
-	sort.Slice(migrations, func(i, j int) bool {
-		return migrations[i].ID < migrations[j].ID
-	})
 	fmt.Println()
 	for _, migration := range migrations {
 		fmt.Println("    " + migration.ID)
@@ -231,10 +280,17 @@ func migration(id string, upFn, downFn func(*weeb.App)error) struct{} {
 	return struct{}{}
 }
 
+// noTxMigration registers a migration run outside of a transaction, e.g. for
+// 'CREATE INDEX CONCURRENTLY'
+func noTxMigration(id string, upFn, downFn func(*weeb.App)error) struct{} {
+	migrations = append(migrations, &weeb.Migration{ID:id, Up: upFn, Down: downFn, NoTx: true})
+	return struct{}{}
+}
+
 // AddMigrationsToApp adds migrations defined in this package to the given 'App'
 func AddMigrationsToApp(app *weeb.App) {
 	for _, m := range migrations {
-		app.Migrations.Add(m.ID, m.Up, m.Down)
+		app.Migrations.AddMigration(m)
 	}
 }
 `))
@@ -0,0 +1,70 @@
+package weeb
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrationRunnerNoTx(t *testing.T) {
+	app := newTestApp(t, nil)
+	db := &txRecordingDB{&recordingDB{}}
+	app.DB = db
+	exec := func(query string) func(app *App) error {
+		return func(app *App) error { return app.DB.Exec(query) }
+	}
+	runner := NewMigrationRunner(app)
+	runner.Add("0001_posts", exec("CREATE TABLE posts"), exec("DROP TABLE posts"))
+	runner.AddNoTx("0002_posts_index", exec("CREATE INDEX CONCURRENTLY posts_idx"), exec("DROP INDEX CONCURRENTLY posts_idx"))
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	if err := runner.RunUp(-1); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"BEGIN",
+		"CREATE TABLE posts",
+		"INSERT INTO migrations (id, created) VALUES ($1, NOW())",
+		"COMMIT",
+		"CREATE INDEX CONCURRENTLY posts_idx",
+		"INSERT INTO migrations (id, created) VALUES ($1, NOW())",
+	}
+	if queries := db.statementQueries(); strings.Join(queries, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(queries, "\n"))
+	}
+
+	db.recordingDB = &recordingDB{OnQueryOne: func(dest interface{}, query string, args []interface{}) error {
+		*dest.(*string) = "0002_posts_index"
+		return nil
+	}}
+	if err := runner.RunDown(1); err != nil {
+		t.Fatal(err)
+	}
+	if queries := db.statementQueries(); len(queries) != 2 || queries[0] != "DROP INDEX CONCURRENTLY posts_idx" ||
+		!strings.HasPrefix(queries[1], "DELETE FROM migrations") {
+		t.Fatalf("expected the index to be dropped outside of a transaction, got %v", queries)
+	}
+}
+
+func TestMigrationRunnerRollsBackFailingMigrations(t *testing.T) {
+	app := newTestApp(t, nil)
+	db := &txRecordingDB{&recordingDB{}}
+	app.DB = db
+	runner := NewMigrationRunner(app)
+	runner.Add("0001_broken", func(app *App) error { return errors.New("syntax error") }, nil)
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	if err := runner.RunUp(-1); err != nil {
+		t.Fatal(err)
+	}
+	if queries := db.statementQueries(); strings.Join(queries, ",") != "BEGIN,ROLLBACK" {
+		t.Fatalf("expected the transaction to be rolled back, got %v", queries)
+	}
+}
@@ -1,5 +1,130 @@
 package weeb
 
 func addWeebMigrationsToApp(app *App) {
-	// app.Migrations.Add("0001_users_table", migrate0001UsersTableUp, migrate0001UsersTableDown)
+	app.Migrations.Add("0001_jobs_table", migrate0001JobsTableUp, migrate0001JobsTableDown)
+	app.Migrations.Add("0002_sessions_table", migrate0002SessionsTableUp, migrate0002SessionsTableDown)
+	app.Migrations.Add("0004_api_tokens_table", migrate0004APITokensTableUp, migrate0004APITokensTableDown)
+	app.Migrations.Add("0005_recovery_codes_table", migrate0005RecoveryCodesTableUp, migrate0005RecoveryCodesTableDown)
+
+	if app.Config.GetBool("accounts") {
+		app.Migrations.Add("0003_users_table", migrate0003UsersTableUp, migrate0003UsersTableDown)
+		app.Migrations.Add("0006_users_totp_secret", migrate0006UsersTOTPSecretUp, migrate0006UsersTOTPSecretDown)
+	}
+}
+
+func migrate0001JobsTableUp(app *App) error {
+	return app.DB.Exec(`
+		CREATE TABLE jobs (
+		  id bigint,
+		  name text NOT NULL,
+		  payload text NOT NULL,
+		  status text NOT NULL,
+		  attempts integer NOT NULL,
+		  max_attempts integer NOT NULL,
+		  last_error text NOT NULL,
+		  run_at timestamptz NOT NULL,
+		  locked_at timestamptz,
+		  created timestamptz NOT NULL,
+		  updated timestamptz NOT NULL,
+		  PRIMARY KEY (id)
+		);
+		CREATE INDEX jobs_status_run_at_idx ON jobs (status, run_at);
+	`)
+}
+
+func migrate0001JobsTableDown(app *App) error {
+	return app.DB.Exec(`DROP TABLE jobs`)
+}
+
+func migrate0002SessionsTableUp(app *App) error {
+	return app.DB.Exec(`
+		CREATE TABLE sessions (
+		  id text,
+		  user_id text NOT NULL,
+		  data text NOT NULL,
+		  expires timestamptz NOT NULL,
+		  created timestamptz NOT NULL,
+		  updated timestamptz NOT NULL,
+		  PRIMARY KEY (id)
+		);
+		CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+	`)
+}
+
+func migrate0002SessionsTableDown(app *App) error {
+	return app.DB.Exec(`DROP TABLE sessions`)
+}
+
+func migrate0003UsersTableUp(app *App) error {
+	return app.DB.Exec(`
+		CREATE TABLE users (
+		  id bigint,
+		  name text NOT NULL,
+		  email text NOT NULL,
+		  password text NOT NULL,
+		  roles text[] NOT NULL,
+		  created timestamptz NOT NULL,
+		  updated timestamptz NOT NULL,
+		  PRIMARY KEY (id)
+		);
+		CREATE UNIQUE INDEX users_email_idx ON users (email);
+
+		CREATE TABLE password_resets (
+		  token_hash text,
+		  user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		  expires timestamptz NOT NULL,
+		  used timestamptz,
+		  created timestamptz NOT NULL,
+		  PRIMARY KEY (token_hash)
+		);
+	`)
+}
+
+func migrate0003UsersTableDown(app *App) error {
+	return app.DB.Exec(`DROP TABLE password_resets; DROP TABLE users`)
+}
+
+func migrate0004APITokensTableUp(app *App) error {
+	return app.DB.Exec(`
+		CREATE TABLE api_tokens (
+		  id bigint,
+		  user_id text NOT NULL,
+		  name text NOT NULL,
+		  token_hash text NOT NULL,
+		  last_used timestamptz,
+		  expires timestamptz,
+		  created timestamptz NOT NULL,
+		  PRIMARY KEY (id)
+		);
+		CREATE UNIQUE INDEX api_tokens_token_hash_idx ON api_tokens (token_hash);
+		CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
+	`)
+}
+
+func migrate0004APITokensTableDown(app *App) error {
+	return app.DB.Exec(`DROP TABLE api_tokens`)
+}
+
+func migrate0005RecoveryCodesTableUp(app *App) error {
+	return app.DB.Exec(`
+		CREATE TABLE recovery_codes (
+		  code_hash text,
+		  user_id text NOT NULL,
+		  created timestamptz NOT NULL,
+		  PRIMARY KEY (code_hash)
+		);
+		CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+	`)
+}
+
+func migrate0005RecoveryCodesTableDown(app *App) error {
+	return app.DB.Exec(`DROP TABLE recovery_codes`)
+}
+
+func migrate0006UsersTOTPSecretUp(app *App) error {
+	return app.DB.Exec(`ALTER TABLE users ADD COLUMN totp_secret text NOT NULL DEFAULT ''`)
+}
+
+func migrate0006UsersTOTPSecretDown(app *App) error {
+	return app.DB.Exec(`ALTER TABLE users DROP COLUMN totp_secret`)
 }
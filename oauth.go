@@ -0,0 +1,334 @@
+package weeb
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrorOAuthNoEmail = errors.New("OAuth identity has no verified email")
+
+// OAuthIdentity is a user's identity as reported by an OAuth provider
+type OAuthIdentity struct {
+	Provider      string
+	ID            string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Info          J
+}
+
+// OAuthProvider defines the endpoints of an OAuth2 provider and how to map
+// it's userinfo response to an OAuthIdentity
+type OAuthProvider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	// EmailsURL, when set, is fetched for a GitHub style list of the user's
+	// addresses ({"email", "primary", "verified"}). The primary one replaces
+	// the identity's email
+	EmailsURL   string
+	Scopes      []string
+	MapUserInfo func(info J) (*OAuthIdentity, error)
+}
+
+// OAuthLinkFn finds or creates the user an external identity belongs to.
+// Returning a nil user denies the signin
+type OAuthLinkFn func(ctx *Context, identity *OAuthIdentity) (AuthUser, error)
+
+// OAuth implements the OAuth2 authorization code flow (with PKCE) for signing
+// in users through external providers. The state and code verifier are kept
+// in the session between the redirect and the callback
+type OAuth struct {
+	auth       *Auth
+	path       string
+	providers  map[string]*OAuthProvider
+	HTTPClient *http.Client
+	Link       OAuthLinkFn
+}
+
+// NewOAuth creates a new OAuth instance
+func NewOAuth(auth *Auth) *OAuth {
+	return &OAuth{
+		auth:       auth,
+		providers:  map[string]*OAuthProvider{},
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Link:       oauthDefaultLink,
+	}
+}
+
+// Register adds a provider, it's pages are available under it's name
+func (o *OAuth) Register(provider *OAuthProvider) {
+	o.providers[provider.Name] = provider
+}
+
+// Provider returns the provider registered under the given name
+func (o *OAuth) Provider(name string) *OAuthProvider {
+	return o.providers[name]
+}
+
+// Mount registers the '{provider}/' page redirecting to the provider and
+// the '{provider}/callback/' page it redirects back to
+func (o *OAuth) Mount(r *Router) {
+	o.path = r.prefix
+	r.Get("/{provider}/", o.handleRedirect)
+	r.Get("/{provider}/callback/", o.handleCallback)
+}
+
+// GoogleOAuthProvider returns a provider for "Sign in with Google" using
+// OpenID Connect
+func GoogleOAuthProvider(clientID, clientSecret string) *OAuthProvider {
+	return &OAuthProvider{
+		Name:         "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+		Scopes:       []string{"openid", "email", "profile"},
+		MapUserInfo: func(info J) (*OAuthIdentity, error) {
+			verified, _ := info["email_verified"].(bool)
+			return &OAuthIdentity{
+				ID:            fmt.Sprint(info["sub"]),
+				Email:         oauthString(info, "email"),
+				EmailVerified: verified,
+				Name:          oauthString(info, "name"),
+			}, nil
+		},
+	}
+}
+
+// GitHubOAuthProvider returns a provider for "Sign in with GitHub"
+func GitHubOAuthProvider(clientID, clientSecret string) *OAuthProvider {
+	return &OAuthProvider{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		// The public email of '/user' isn't necessarily verified
+		EmailsURL: "https://api.github.com/user/emails",
+		Scopes:    []string{"read:user", "user:email"},
+		MapUserInfo: func(info J) (*OAuthIdentity, error) {
+			name := oauthString(info, "name")
+			if name == "" {
+				name = oauthString(info, "login")
+			}
+			return &OAuthIdentity{
+				ID:   fmt.Sprint(info["id"]),
+				Name: name,
+			}, nil
+		},
+	}
+}
+
+func oauthString(info J, key string) string {
+	value, _ := info[key].(string)
+	return value
+}
+
+func (o *OAuth) redirectURL(ctx *Context, provider *OAuthProvider) string {
+	return ctx.Config.Get("url", "http://localhost:"+ctx.Config.Get("port", "3000")) +
+		strings.TrimRight(o.path, "/") + "/" + provider.Name + "/callback/"
+}
+
+func (o *OAuth) handleRedirect(ctx *Context) error {
+	provider := o.providers[ctx.Param("provider", "")]
+	if provider == nil {
+		return ctx.Error(404, "not found")
+	}
+
+	state := generateRandomKey(32)
+	verifier := generateRandomKey(64)
+	challenge := sha256.Sum256([]byte(verifier))
+	ctx.Session.Set("oauthState", state)
+	ctx.Session.Set("oauthVerifier", verifier)
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", o.redirectURL(ctx, provider))
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	authURL := provider.AuthURL + "?"
+	if strings.Contains(provider.AuthURL, "?") {
+		authURL = provider.AuthURL + "&"
+	}
+	return ctx.Redirect(authURL + query.Encode())
+}
+
+func (o *OAuth) handleCallback(ctx *Context) error {
+	provider := o.providers[ctx.Param("provider", "")]
+	if provider == nil {
+		return ctx.Error(404, "not found")
+	}
+
+	// The state and verifier are single use
+	state := ctx.Session.Get("oauthState")
+	verifier := ctx.Session.Get("oauthVerifier")
+	ctx.Session.Delete("oauthState")
+	ctx.Session.Delete("oauthVerifier")
+
+	if err := ctx.Param("error", ""); err != "" {
+		ctx.Log.Warning("oauth signin denied", L{"provider": provider.Name, "err": err})
+		return ctx.Error(401, "signin denied by provider")
+	}
+	given := ctx.Param("state", "")
+	if state == "" || subtle.ConstantTimeCompare([]byte(given), []byte(state)) != 1 {
+		return ctx.Error(401, "invalid oauth state")
+	}
+
+	accessToken, err := o.exchange(ctx, provider, ctx.Param("code", ""), verifier)
+	if err != nil {
+		ctx.Log.Warning("oauth code exchange failed", L{"provider": provider.Name, "err": err.Error()})
+		return ctx.Error(401, "oauth code exchange failed")
+	}
+	identity, err := o.userInfo(provider, accessToken)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+
+	user, err := o.Link(ctx, identity)
+	if err != nil {
+		return ctx.HandleError(err)
+	}
+	if user == nil {
+		return ctx.Error(403, "forbidden")
+	}
+	if totpSecret(user) != "" {
+		// Like a password signin, wait for VerifySecondFactor
+		ctx.Session.Set("pendingUserID", user.AuthID())
+		accountsPath := strings.TrimRight(ctx.Config.Get("accountsPath", "/account/"), "/")
+		return ctx.Redirect(ctx.Config.Get("oauthTwoFactorRedirect", accountsPath+"/two-factor/"))
+	}
+	o.auth.SigninUser(ctx, user)
+	ctx.Log.Info("user signed in with oauth", L{"provider": provider.Name, "userID": user.AuthID()})
+	return ctx.Redirect(ctx.Config.Get("oauthRedirect", "/"))
+}
+
+// exchange trades an authorization code for an access token
+func (o *OAuth) exchange(ctx *Context, provider *OAuthProvider, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.redirectURL(ctx, provider))
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	result := struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := o.doJSON(req, &result); err != nil {
+		return "", err
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("OAuth: %s: %s", result.Error, result.ErrorDescription)
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("OAuth: no access token returned")
+	}
+	return result.AccessToken, nil
+}
+
+// userInfo fetches the provider's userinfo endpoint and maps it to an identity
+func (o *OAuth) userInfo(provider *OAuthProvider, accessToken string) (*OAuthIdentity, error) {
+	info := J{}
+	if err := o.get(provider.UserInfoURL, accessToken, &info); err != nil {
+		return nil, err
+	}
+	identity, err := provider.MapUserInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	identity.Provider = provider.Name
+	identity.Info = info
+	if identity.ID == "" || identity.ID == "<nil>" {
+		return nil, errors.New("OAuth: userinfo response has no user id")
+	}
+	if provider.EmailsURL != "" {
+		if identity.Email, identity.EmailVerified, err = o.primaryEmail(provider, accessToken); err != nil {
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+// primaryEmail returns the user's primary address from the provider's
+// EmailsURL and whether the provider verified it
+func (o *OAuth) primaryEmail(provider *OAuthProvider, accessToken string) (string, bool, error) {
+	emails := []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}{}
+	if err := o.get(provider.EmailsURL, accessToken, &emails); err != nil {
+		return "", false, err
+	}
+	for _, email := range emails {
+		if email.Primary {
+			return email.Email, email.Verified, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (o *OAuth) get(url, accessToken string, value interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return o.doJSON(req, value)
+}
+
+func (o *OAuth) doJSON(req *http.Request, value interface{}) error {
+	res, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("OAuth: %s returned %d", req.URL.Host, res.StatusCode)
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	// Keep numeric ids intact
+	decoder.UseNumber()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("OAuth: invalid response from %s (%d): %s", req.URL.Host, res.StatusCode, err.Error())
+	}
+	return nil
+}
+
+func oauthDefaultLink(ctx *Context, identity *OAuthIdentity) (AuthUser, error) {
+	panic("OAuth: Link was not configured")
+}
@@ -0,0 +1,162 @@
+package weeb
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newFakeGitHub serves the token, user and emails endpoints of a GitHub
+// like provider. The token endpoint checks the PKCE verifier against the
+// challenge of the authorize redirect saved in `challenge`
+func newFakeGitHub(t *testing.T, challenge *string, emails []J) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+			json.NewEncoder(w).Encode(J{"error": "invalid_grant", "error_description": "bad code or verifier"})
+			return
+		}
+		json.NewEncoder(w).Encode(J{"access_token": "the-token", "token_type": "bearer"})
+	})
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer the-token" {
+			w.WriteHeader(401)
+			json.NewEncoder(w).Encode(J{"message": "Bad credentials"})
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode(J{"id": 12345678901234, "login": "octocat", "email": "public@example.com"})
+		}
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode(emails)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// oauthSignin runs the redirect and callback pages against a fake GitHub
+// returning the given emails, and returns the linked identity
+func oauthSignin(t *testing.T, emails []J) (*OAuthIdentity, *httptest.ResponseRecorder) {
+	return oauthSigninAs(t, &testUser{id: "1"}, emails)
+}
+
+func oauthSigninAs(t *testing.T, user AuthUser, emails []J) (*OAuthIdentity, *httptest.ResponseRecorder) {
+	app := newTestApp(t, nil)
+	app.Auth.FindByID = func(ctx *Context, id string) (AuthUser, error) {
+		return &testUser{id: id}, nil
+	}
+	challenge := ""
+	server := newFakeGitHub(t, &challenge, emails)
+
+	provider := GitHubOAuthProvider("client", "secret")
+	provider.AuthURL = server.URL + "/login/oauth/authorize"
+	provider.TokenURL = server.URL + "/login/oauth/access_token"
+	provider.UserInfoURL = server.URL + "/user"
+	provider.EmailsURL = server.URL + "/user/emails"
+	app.Auth.OAuth.Register(provider)
+	var identity *OAuthIdentity
+	app.Auth.OAuth.Link = func(ctx *Context, i *OAuthIdentity) (AuthUser, error) {
+		identity = i
+		return user, nil
+	}
+	app.Auth.OAuth.Mount(app.Router.Group("/auth/"))
+
+	w := serve(app, httptest.NewRequest("GET", "/auth/github/", nil))
+	if w.Code != 302 {
+		t.Fatalf("expected a redirect to the provider, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if location.Path != "/login/oauth/authorize" || query.Get("code_challenge_method") != "S256" ||
+		query.Get("redirect_uri") != "http://localhost:3000/auth/github/callback/" {
+		t.Fatalf("unexpected authorize redirect %s", location)
+	}
+	challenge = query.Get("code_challenge")
+
+	callback := "/auth/github/callback/?code=the-code&state=" + url.QueryEscape(query.Get("state"))
+	w = serve(app, httptest.NewRequest("GET", callback, nil), responseCookie(w, "_app_session"))
+
+	// Expose the resulting session on the recorder's body
+	app.Router.Get("/session/", func(ctx *Context) error {
+		return ctx.Text(200, ctx.Session.Get("userID")+"|"+ctx.Session.Get("pendingUserID"))
+	})
+	if cookie := responseCookie(w, "_app_session"); cookie != nil {
+		session := serve(app, httptest.NewRequest("GET", "/session/", nil), cookie)
+		w.Body.Reset()
+		w.Body.WriteString(session.Body.String())
+	}
+	return identity, w
+}
+
+func TestOAuthGitHubFlow(t *testing.T) {
+	identity, w := oauthSignin(t, []J{
+		{"email": "old@example.com", "primary": false, "verified": true},
+		{"email": "octocat@example.com", "primary": true, "verified": true},
+	})
+	if w.Code != 302 || w.Header().Get("Location") != "/" {
+		t.Fatalf("expected a redirect after signin, got %d %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "1|" {
+		t.Fatalf("expected user 1 to be signed in, session has %q", w.Body.String())
+	}
+	if identity == nil || identity.Provider != "github" || identity.ID != "12345678901234" || identity.Name != "octocat" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if identity.Email != "octocat@example.com" || !identity.EmailVerified {
+		t.Fatalf("expected the verified primary email, got %q (verified %v)", identity.Email, identity.EmailVerified)
+	}
+}
+
+func TestOAuthGitHubUnverifiedEmail(t *testing.T) {
+	identity, _ := oauthSignin(t, []J{
+		{"email": "victim@example.com", "primary": true, "verified": false},
+	})
+	if identity == nil || identity.EmailVerified {
+		t.Fatalf("expected an unverified email, got %+v", identity)
+	}
+}
+
+func TestOAuthCallbackChecksState(t *testing.T) {
+	app := newTestApp(t, nil)
+	app.Auth.OAuth.Register(GitHubOAuthProvider("client", "secret"))
+	app.Auth.OAuth.Mount(app.Router.Group("/auth/"))
+
+	w := serve(app, httptest.NewRequest("GET", "/auth/github/", nil))
+	w = serve(app, httptest.NewRequest("GET", "/auth/github/callback/?code=the-code&state=forged", nil), responseCookie(w, "_app_session"))
+	if w.Code != 401 {
+		t.Fatalf("expected 401 for a forged state, got %d", w.Code)
+	}
+}
+
+type testTOTPUser struct {
+	testUser
+}
+
+func (u *testTOTPUser) AuthTOTPSecret() string { return "JBSWY3DPEHPK3PXP" }
+
+func TestOAuthRequiresSecondFactor(t *testing.T) {
+	_, w := oauthSigninAs(t, &testTOTPUser{testUser{id: "2"}}, []J{
+		{"email": "octocat@example.com", "primary": true, "verified": true},
+	})
+	if w.Code != 302 || w.Header().Get("Location") != "/account/two-factor/" {
+		t.Fatalf("expected a redirect to the two-factor page, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w.Body.String() != "|2" {
+		t.Fatalf("expected user 2 to be pending, session has %q", w.Body.String())
+	}
+}
@@ -0,0 +1,200 @@
+package weeb
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrorUnknownPasswordHash = errors.New("Unknown password hash format")
+
+// PasswordHasher hashes and verifies passwords. Hashes encode the algorithm
+// and it's parameters so they can be verified after the defaults change
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// Handles tells if the hash was created by this hasher's algorithm
+	Handles(hash string) bool
+	// NeedsRehash tells if the hash was created with other parameters than
+	// the hasher's current ones
+	NeedsRehash(hash string) bool
+}
+
+// AuthPasswordUpdater is implemented by users whose password hash can be
+// updated, Signin uses it to upgrade outdated hashes
+type AuthPasswordUpdater interface {
+	AuthUpdatePassword(ctx *Context, hash string) error
+}
+
+// NewPasswordHasher creates the hasher configured by 'passwordHasher'
+// ("bcrypt" or "argon2id", "bcrypt" by default) and it's parameters. It
+// panics when they are invalid
+func NewPasswordHasher(config *Config) PasswordHasher {
+	switch name := config.Get("passwordHasher", "bcrypt"); name {
+	case "bcrypt":
+		return &BcryptHasher{Cost: config.GetInt("bcryptCost", bcrypt.DefaultCost)}
+	case "argon2id":
+		time := config.GetInt("argon2Time", 2)
+		memory := config.GetInt("argon2Memory", 19*1024)
+		threads := config.GetInt("argon2Threads", 1)
+		if time < 1 || memory < 1 || threads < 1 || threads > 255 {
+			panic("invalid argon2 parameters: argon2Time, argon2Memory and argon2Threads (at most 255) must be positive")
+		}
+		return &Argon2idHasher{Time: uint32(time), Memory: uint32(memory), Threads: uint8(threads)}
+	default:
+		panic("unknown password hasher: " + name)
+	}
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id. Hashes use the PHC string
+// format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+const argon2SaltLength = 16
+const argon2KeyLength = 32
+
+var ErrorInvalidArgon2Parameters = errors.New("Argon2 time, memory and threads must be positive")
+
+// valid tells if the parameters can be used, argon2.IDKey panics on 0 threads
+func (h *Argon2idHasher) valid() bool {
+	return h.Time >= 1 && h.Memory >= 1 && h.Threads >= 1
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	if !h.valid() {
+		return "", ErrorInvalidArgon2Parameters
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := h.decode(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, key, err := h.decode(hash)
+	return err != nil || *params != *h || len(key) != argon2KeyLength
+}
+
+func (h *Argon2idHasher) decode(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrorUnknownPasswordHash
+	}
+	version := 0
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrorUnknownPasswordHash
+	}
+	params := &Argon2idHasher{}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || !params.valid() {
+		return nil, nil, nil, ErrorUnknownPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrorUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	// An empty key would match any password
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrorUnknownPasswordHash
+	}
+	return params, salt, key, nil
+}
+
+// HashPassword hashes a password with the configured PasswordHasher
+func (a *Auth) HashPassword(password string) (string, error) {
+	return a.PasswordHasher.Hash(password)
+}
+
+// VerifyPassword checks a password against a hash created by any of the
+// supported algorithms
+func (a *Auth) VerifyPassword(hash, password string) (bool, error) {
+	for _, hasher := range a.passwordHashers() {
+		if hasher.Handles(hash) {
+			return hasher.Verify(hash, password)
+		}
+	}
+	return false, ErrorUnknownPasswordHash
+}
+
+// PasswordNeedsRehash tells if a hash wasn't created by the configured
+// PasswordHasher with it's current parameters
+func (a *Auth) PasswordNeedsRehash(hash string) bool {
+	return !a.PasswordHasher.Handles(hash) || a.PasswordHasher.NeedsRehash(hash)
+}
+
+func (a *Auth) passwordHashers() []PasswordHasher {
+	return []PasswordHasher{a.PasswordHasher, &BcryptHasher{}, &Argon2idHasher{}}
+}
+
+// rehashPassword upgrades the user's password hash when it's outdated and
+// the user implements AuthPasswordUpdater
+func (a *Auth) rehashPassword(ctx *Context, user AuthUser, password string) {
+	updater, ok := user.(AuthPasswordUpdater)
+	if !ok || !a.PasswordNeedsRehash(user.AuthPassword()) {
+		return
+	}
+	hash, err := a.HashPassword(password)
+	if err == nil {
+		err = updater.AuthUpdatePassword(ctx, hash)
+	}
+	if err != nil {
+		ctx.Log.Error("error rehashing password", L{"userID": user.AuthID(), "err": err.Error()})
+		return
+	}
+	ctx.Log.Info("password rehashed", L{"userID": user.AuthID()})
+}
@@ -0,0 +1,104 @@
+package weeb
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashersRoundTrip(t *testing.T) {
+	hashers := []PasswordHasher{
+		&BcryptHasher{Cost: bcrypt.MinCost},
+		&Argon2idHasher{Time: 1, Memory: 64, Threads: 1},
+	}
+	for _, hasher := range hashers {
+		hash, err := hasher.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasher.Handles(hash) || hasher.NeedsRehash(hash) {
+			t.Fatalf("expected %T to handle it's own hash %s", hasher, hash)
+		}
+		if ok, err := hasher.Verify(hash, "correct horse"); !ok || err != nil {
+			t.Fatalf("expected %T to verify the password, got %v (%v)", hasher, ok, err)
+		}
+		if ok, err := hasher.Verify(hash, "battery staple"); ok || err != nil {
+			t.Fatalf("expected %T to refuse a wrong password, got %v (%v)", hasher, ok, err)
+		}
+	}
+}
+
+func TestArgon2idHasherBadHashes(t *testing.T) {
+	hasher := &Argon2idHasher{Time: 1, Memory: 64, Threads: 1}
+	for _, hash := range []string{
+		"",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+	} {
+		if ok, err := hasher.Verify(hash, "password"); ok || err != ErrorUnknownPasswordHash {
+			t.Fatalf("expected '%s' to be refused, got %v (%v)", hash, ok, err)
+		}
+		if !hasher.NeedsRehash(hash) {
+			t.Fatalf("expected '%s' to need a rehash", hash)
+		}
+	}
+	if _, err := (&Argon2idHasher{Time: 1, Memory: 64}).Hash("password"); err != ErrorInvalidArgon2Parameters {
+		t.Fatalf("expected 0 threads to be refused, got %v", err)
+	}
+	if ok, err := (&BcryptHasher{}).Verify("$2a$nope", "password"); ok || err == nil {
+		t.Fatalf("expected a bad bcrypt hash to error, got %v (%v)", ok, err)
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	argon2 := &Argon2idHasher{Time: 1, Memory: 64, Threads: 1}
+	hash, _ := argon2.Hash("password")
+	if !(&Argon2idHasher{Time: 2, Memory: 64, Threads: 1}).NeedsRehash(hash) {
+		t.Fatal("expected an argon2 hash with a lower time to need a rehash")
+	}
+	if !(&Argon2idHasher{Time: 1, Memory: 128, Threads: 1}).NeedsRehash(hash) {
+		t.Fatal("expected an argon2 hash with less memory to need a rehash")
+	}
+
+	bcryptHash, _ := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("password")
+	if !(&BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(bcryptHash) {
+		t.Fatal("expected a bcrypt hash with a lower cost to need a rehash")
+	}
+
+	// Auth verifies hashes of any algorithm but upgrades them
+	app := newTestApp(t, nil)
+	app.Auth.PasswordHasher = argon2
+	if ok, err := app.Auth.VerifyPassword(bcryptHash, "password"); !ok || err != nil {
+		t.Fatalf("expected the bcrypt hash to verify, got %v (%v)", ok, err)
+	}
+	if !app.Auth.PasswordNeedsRehash(bcryptHash) || app.Auth.PasswordNeedsRehash(hash) {
+		t.Fatal("expected only the bcrypt hash to need a rehash")
+	}
+}
+
+func TestNewPasswordHasherRejectsInvalidArgon2Parameters(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"APP_ARGON2_THREADS": "0"},
+		{"APP_ARGON2_THREADS": "256"},
+		{"APP_ARGON2_TIME": "0"},
+		{"APP_ARGON2_MEMORY": "0"},
+	} {
+		env["APP_PASSWORD_HASHER"] = "argon2id"
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(string), "invalid argon2 parameters") {
+					t.Fatalf("expected %v to be refused, got %v", env, r)
+				}
+			}()
+			newTestApp(t, env)
+		}()
+	}
+}
@@ -0,0 +1,128 @@
+package weeb
+
+import (
+	"reflect"
+)
+
+// PolicyFn decides if `user` can perform an action on `resource`. `user` is
+// nil for anonymous requests
+type PolicyFn func(ctx *Context, user AuthUser, resource interface{}) bool
+
+// canFn is what the 'can' template function calls, it's set in the request
+// data by the auth middleware
+type canFn func(action string, resource interface{}) bool
+
+// Grant gives the permissions to users having `role`. The "*" permission
+// grants every permission
+func (a *Auth) Grant(role string, permissions ...string) {
+	if a.permissions == nil {
+		a.permissions = map[string][]string{}
+	}
+	a.permissions[role] = append(a.permissions[role], permissions...)
+}
+
+// Policy registers the function deciding if users can perform `action` on
+// resources of the same type as `resource`. For example:
+//
+//	app.Auth.Policy("edit", &Post{}, func(ctx *weeb.Context, user weeb.AuthUser, resource interface{}) bool {
+//	    return user != nil && resource.(*Post).AuthorID == user.AuthID()
+//	})
+func (a *Auth) Policy(action string, resource interface{}, fn PolicyFn) {
+	if a.policies == nil {
+		a.policies = map[string]PolicyFn{}
+	}
+	a.policies[policyKey(action, resource)] = fn
+}
+
+func policyKey(action string, resource interface{}) string {
+	if resource == nil {
+		return action
+	}
+	return action + ":" + reflect.TypeOf(resource).String()
+}
+
+// HasPermission checks if any of the user's roles grants `permission`
+func (a *Auth) HasPermission(user AuthUser, permission string) bool {
+	if user == nil {
+		return false
+	}
+	for _, role := range user.AuthRoles() {
+		for _, granted := range a.permissions[role] {
+			if granted == permission || granted == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Can checks if the current user can perform `action` on `resource`. The
+// policy registered for the action and the resource's type decides, without
+// one the user needs the permission named `action`
+func (a *Auth) Can(ctx *Context, action string, resource interface{}) bool {
+	user, err := a.CurrentUser(ctx)
+	if err != nil {
+		ctx.Log.Error("error loading current user", L{"err": err.Error()})
+		return false
+	}
+	if policy, ok := a.policies[policyKey(action, resource)]; ok {
+		return policy(ctx, user, resource)
+	}
+	return a.HasPermission(user, action)
+}
+
+// RequireAny returns a middleware only letting through users with at least
+// one of the given permissions
+func (a *Auth) RequireAny(permissions ...string) func(HandlerFunc) HandlerFunc {
+	return a.requirePermissions(permissions, false)
+}
+
+// RequireAll returns a middleware only letting through users with all of
+// the given permissions
+func (a *Auth) RequireAll(permissions ...string) func(HandlerFunc) HandlerFunc {
+	return a.requirePermissions(permissions, true)
+}
+
+func (a *Auth) requirePermissions(permissions []string, all bool) func(HandlerFunc) HandlerFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) error {
+			user, err := ctx.Auth.CurrentUser(ctx)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				return ctx.Error(401, "unauthorized")
+			}
+
+			granted := 0
+			for _, permission := range permissions {
+				if a.HasPermission(user, permission) {
+					granted++
+				}
+			}
+			if (all && granted < len(permissions)) || (!all && granted == 0) {
+				return ctx.Error(403, "forbidden")
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// can is the 'can' template function, `{{ if can . "edit" .post }}`. The
+// resource is optional, `{{ if can $ "posts.create" }}` checks a permission
+func can(data interface{}, action string, resource ...interface{}) bool {
+	var fn canFn
+	switch values := data.(type) {
+	case J:
+		fn, _ = values["can"].(canFn)
+	case map[string]interface{}:
+		fn, _ = values["can"].(canFn)
+	}
+	if fn == nil {
+		return false
+	}
+	if len(resource) > 0 {
+		return fn(action, resource[0])
+	}
+	return fn(action, nil)
+}
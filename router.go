@@ -10,6 +10,7 @@ type HandlerFunc func(*Context) error
 
 type Router struct {
 	app           *App
+	prefix        string
 	router        *mux.Router
 	ErrorHandlers map[int]HandlerFunc
 }
@@ -49,7 +50,7 @@ func (r *Router) Group(prefix string) *Router {
 	muxRouter := mux.NewRouter()
 	r.router.PathPrefix(prefix).Handler(muxRouter)
 	muxSubRouter := muxRouter.PathPrefix(prefix).Subrouter()
-	newRouter := &Router{app: r.app, router: muxSubRouter, ErrorHandlers: r.ErrorHandlers}
+	newRouter := &Router{app: r.app, prefix: prefix, router: muxSubRouter, ErrorHandlers: r.ErrorHandlers}
 	newRouter.init()
 	return newRouter
 }
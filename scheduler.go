@@ -0,0 +1,307 @@
+package weeb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CronSchedule represents a parsed 5-field cron expression
+// (minute, hour, day of month, month, day of week)
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseCronSchedule parses a standard 5-field cron expression. Fields support
+// '*', lists ('1,2'), ranges ('1-5'), steps ('*/15', '0-30/5') and month/day
+// names. Macros like '@hourly' and '@daily' are also accepted
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in '%s'", len(fields), spec)
+	}
+
+	s := &CronSchedule{}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	// Both 0 and 7 mean sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in '%s'", field)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" && part != "?" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, fmt.Errorf("cron: invalid value in '%s'", field)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], names); err != nil {
+					return 0, fmt.Errorf("cron: invalid value in '%s'", field)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("cron: value out of range [%d-%d] in '%s'", min, max, field)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	// Like cron, when both day fields are restricted either one can match
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time matching the schedule strictly after `t`
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Give up after 5 years, the schedule can't be matched (e.g. Feb 30th)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// ScheduleEntry represents a task registered to run on a cron schedule
+type ScheduleEntry struct {
+	Name     string
+	Spec     string
+	Schedule *CronSchedule
+	Fn       TaskFn
+}
+
+// Scheduler runs scheduled tasks on their cron schedules
+type Scheduler struct {
+	app     *App
+	entries []*ScheduleEntry
+	stop    chan struct{}
+	wg      *sync.WaitGroup
+}
+
+// NewScheduler creates a new Scheduler instance
+func NewScheduler(app *App) *Scheduler {
+	return &Scheduler{app: app, entries: []*ScheduleEntry{}, wg: &sync.WaitGroup{}}
+}
+
+// Add registers a task function to run on the given cron schedule
+func (s *Scheduler) Add(spec, name string, fn TaskFn) error {
+	schedule, err := ParseCronSchedule(spec)
+	if err != nil {
+		return err
+	}
+	s.entries = append(s.entries, &ScheduleEntry{Name: name, Spec: spec, Schedule: schedule, Fn: fn})
+	return nil
+}
+
+// Entries returns all registered schedule entries sorted by name
+func (s *Scheduler) Entries() []*ScheduleEntry {
+	entries := append([]*ScheduleEntry{}, s.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Start starts the scheduler goroutine
+func (s *Scheduler) Start() {
+	if s.stop != nil || len(s.entries) == 0 {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.loop(s.stop)
+}
+
+// Stop stops the scheduler and waits for running tasks to finish
+func (s *Scheduler) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.stop = nil
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(stop chan struct{}) {
+	defer s.wg.Done()
+
+	now := time.Now()
+	next := map[*ScheduleEntry]time.Time{}
+	for _, entry := range s.entries {
+		next[entry] = entry.Schedule.Next(now)
+	}
+
+	for {
+		wakeAt := time.Time{}
+		for _, t := range next {
+			if !t.IsZero() && (wakeAt.IsZero() || t.Before(wakeAt)) {
+				wakeAt = t
+			}
+		}
+		if wakeAt.IsZero() {
+			<-stop
+			return
+		}
+
+		timer := time.NewTimer(time.Until(wakeAt))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case now = <-timer.C:
+		}
+
+		for _, entry := range s.entries {
+			if t := next[entry]; !t.IsZero() && !t.After(now) {
+				s.wg.Add(1)
+				go s.run(entry)
+				next[entry] = entry.Schedule.Next(now)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) run(entry *ScheduleEntry) {
+	defer s.wg.Done()
+	defer func() {
+		if err := recover(); err != nil {
+			s.app.Log.Error("scheduled task panic", L{"task": entry.Name, "err": fmt.Sprintf("%v", err)})
+		}
+	}()
+
+	start := time.Now()
+	if err := entry.Fn(s.app, []string{}); err != nil {
+		s.app.Log.Error("scheduled task failed", L{"task": entry.Name, "err": err.Error()})
+		return
+	}
+	s.app.Log.Info("scheduled task done", L{"task": entry.Name, "ms": int64(time.Since(start) / time.Millisecond)})
+}
+
+// Tasks
+
+func schedulerTask(app *App, args []string) error {
+	n := 5
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+			return errors.New("usage: schedule [number of upcoming runs to show]")
+		}
+	}
+
+	entries := app.Scheduler.Entries()
+	fmt.Println()
+	if len(entries) == 0 {
+		fmt.Printf("There is 0 scheduled task registered\n\n")
+		return nil
+	}
+	for _, entry := range entries {
+		fmt.Printf("    %s  (%s)\n", entry.Name, entry.Spec)
+		t := time.Now()
+		for i := 0; i < n; i++ {
+			t = entry.Schedule.Next(t)
+			if t.IsZero() {
+				break
+			}
+			fmt.Printf("        %s\n", t.Format("2006-01-02 15:04 MST"))
+		}
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,103 @@
+package weeb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	s, err := ParseCronSchedule("*/15 9-17 1,15 jan-mar mon-fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.minute != 1|1<<15|1<<30|1<<45 {
+		t.Fatalf("unexpected minutes %b", s.minute)
+	}
+	if s.hour != (1<<18-1)&^(1<<9-1) {
+		t.Fatalf("unexpected hours %b", s.hour)
+	}
+	if s.dom != 1<<1|1<<15 || s.domStar {
+		t.Fatalf("unexpected days of month %b", s.dom)
+	}
+	if s.month != 1<<1|1<<2|1<<3 {
+		t.Fatalf("unexpected months %b", s.month)
+	}
+	if s.dow != 1<<1|1<<2|1<<3|1<<4|1<<5 || s.dowStar {
+		t.Fatalf("unexpected days of week %b", s.dow)
+	}
+
+	s, _ = ParseCronSchedule("0-30/10 5/6 * * 7")
+	if s.minute != 1|1<<10|1<<20|1<<30 {
+		t.Fatalf("unexpected stepped range %b", s.minute)
+	}
+	if s.hour != 1<<5|1<<11|1<<17|1<<23 {
+		t.Fatalf("unexpected stepped start %b", s.hour)
+	}
+	if s.dow&1 == 0 {
+		t.Fatal("expected 7 to mean sunday")
+	}
+
+	if s, err = ParseCronSchedule("@daily"); err != nil || s.minute != 1 || s.hour != 1 || !s.domStar {
+		t.Fatalf("unexpected @daily schedule %+v (%v)", s, err)
+	}
+
+	for _, spec := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"* * * foo *",
+	} {
+		if _, err := ParseCronSchedule(spec); err == nil {
+			t.Fatalf("expected '%s' to be invalid", spec)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	cases := []struct {
+		spec, from, next string
+	}{
+		// Strictly after, seconds ignored
+		{"*/15 * * * *", "2021-03-10 10:15", "2021-03-10 10:30"},
+		{"0 0 * * *", "2021-12-31 23:59", "2022-01-01 00:00"},
+		// Month ends and leap years
+		{"0 0 31 * *", "2021-04-01 00:00", "2021-05-31 00:00"},
+		{"0 12 29 2 *", "2021-01-01 00:00", "2024-02-29 12:00"},
+		{"@monthly", "2021-01-31 12:00", "2021-02-01 00:00"},
+		// 2021-03-14 is a sunday
+		{"30 8 * * 0", "2021-03-10 09:00", "2021-03-14 08:30"},
+		{"30 8 * * 7", "2021-03-14 08:30", "2021-03-21 08:30"},
+		{"0 9 * * mon-fri", "2021-03-12 10:00", "2021-03-15 09:00"},
+		// Either day field matches when both are restricted
+		{"0 0 1 * mon", "2021-03-02 00:00", "2021-03-08 00:00"},
+		{"0 0 1 * mon", "2021-03-29 00:00", "2021-04-01 00:00"},
+	}
+	for _, c := range cases {
+		s, err := ParseCronSchedule(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := s.Next(at(c.from).Add(30 * time.Second)); !next.Equal(at(c.next)) {
+			t.Fatalf("expected '%s' after %s to be %s, got %s", c.spec, c.from, c.next, next)
+		}
+	}
+
+	s, _ := ParseCronSchedule("0 0 30 2 *")
+	if next := s.Next(at("2021-01-01 00:00")); !next.IsZero() {
+		t.Fatalf("expected february 30th to never match, got %s", next)
+	}
+}
@@ -2,6 +2,7 @@ package weeb
 
 import (
 	"encoding/json"
+	"strings"
 )
 
 // Text sends the given text back as response (with given status code)
@@ -43,3 +44,19 @@ func (ctx *Context) HTML(code int, template string, value J) error {
 	ctx.SetBody(contents)
 	return nil
 }
+
+// ValidationError sends the validator's errors back with a 422 status code.
+// API requests get them as json keyed by field, others get `template`
+// rendered with them set as 'errors'
+func (ctx *Context) ValidationError(v *Validator, template string, value J) error {
+	if ctx.wantsJSON() {
+		return ctx.JSON(422, J{"errors": v.FieldErrors()})
+	}
+	ctx.Set("errors", v.FieldErrors())
+	return ctx.HTML(422, template, value)
+}
+
+func (ctx *Context) wantsJSON() bool {
+	return strings.Contains(ctx.Request.Header.Get("Accept"), "application/json") ||
+		strings.Contains(ctx.Request.Header.Get("Content-Type"), "application/json")
+}
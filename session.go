@@ -1,6 +1,8 @@
 package weeb
 
 import (
+	"encoding/json"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/sessions"
@@ -20,7 +22,10 @@ type Flash struct {
 
 type Session struct {
 	ctx   *Context
-	store *sessions.CookieStore
+	store sessions.Store
+	// modified tells if the request changed the session, such responses are
+	// specific to the session and aren't cached by CacheResponses
+	modified bool
 }
 
 func NewSession(ctx *Context) *Session {
@@ -29,17 +34,7 @@ func NewSession(ctx *Context) *Session {
 
 func (s *Session) ensureStore() {
 	if s.store == nil {
-		secret := s.ctx.App().Config.Get("secret", "")
-		if secret == "" {
-			panic("Session: no 'secret' config is set. Use the 'generate-session-key' to generate one")
-		}
-		secretParts := strings.Split(secret, ",")
-		if len(secretParts) == 2 {
-			// Allow key rotations by splitting the secret config on the ','
-			s.store = sessions.NewCookieStore([]byte(secretParts[0]), nil, []byte(secretParts[1]), nil)
-		} else {
-			s.store = sessions.NewCookieStore([]byte(secret))
-		}
+		s.store = s.ctx.App().sessionStore()
 	}
 }
 
@@ -51,38 +46,164 @@ func (s *Session) save() {
 	if err != nil {
 		s.ctx.Log.Error("error saving session", L{"err": err})
 	}
+	s.setSameSite()
+}
+
+// setSameSite adds the 'SameSite' attribute to the session cookie, according
+// to the 'sessionSameSite' config ("lax", "strict", "none" or "off")
+func (s *Session) setSameSite() {
+	sameSite := strings.ToLower(s.ctx.Config.Get("sessionSameSite", "lax"))
+	if sameSite == "off" {
+		return
+	}
+	sameSite = title(sameSite)
+	prefix := s.name() + "="
+	cookies := s.ctx.Response.Header()["Set-Cookie"]
+	for i, cookie := range cookies {
+		if strings.HasPrefix(cookie, prefix) && !strings.Contains(cookie, "SameSite=") {
+			cookies[i] = cookie + "; SameSite=" + sameSite
+		}
+	}
+}
+
+func (s *Session) name() string {
+	return s.ctx.Config.Get("name", "_app_session")
 }
 
 func (s *Session) GetSession() *sessions.Session {
 	s.ensureStore()
-	sessionName := s.ctx.App().Config.Get("name", "_app_session")
-	session, err := s.store.Get(s.ctx.Request, sessionName)
+	session, err := s.store.Get(s.ctx.Request, s.name())
 	if err != nil {
 		s.ctx.Log.Error("error parsing session", L{"err": err})
 	}
 	session.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   86400 * 7,
+		Domain:   s.ctx.Config.Get("sessionDomain", ""),
+		MaxAge:   sessionMaxAge(s.ctx.Config),
+		Secure:   s.ctx.Config.GetBool("sessionSecure"),
 		HttpOnly: true,
 	}
 	return session
 }
 
+// Get returns the string value stored under `key` or "" if there is none
 func (s *Session) Get(key string) string {
 	session := s.GetSession()
-	if value, ok := session.Values[key]; ok {
-		return value.(string)
+	if value, ok := session.Values[key].(string); ok {
+		return value
 	}
 	return ""
 }
 
 func (s *Session) Set(key, value string) {
 	session := s.GetSession()
+	s.modified = true
+	session.Values[key] = value
+}
+
+// GetInt returns the int value stored under `key` or 0 if there is none
+func (s *Session) GetInt(key string) int {
+	session := s.GetSession()
+	switch value := session.Values[key].(type) {
+	case int:
+		return value
+	case string:
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	return 0
+}
+
+func (s *Session) SetInt(key string, value int) {
+	session := s.GetSession()
+	s.modified = true
+	session.Values[key] = value
+}
+
+// GetBool returns the bool value stored under `key` or false if there is none
+func (s *Session) GetBool(key string) bool {
+	session := s.GetSession()
+	switch value := session.Values[key].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "1" || value == "true"
+	}
+	return false
+}
+
+func (s *Session) SetBool(key string, value bool) {
+	session := s.GetSession()
+	s.modified = true
 	session.Values[key] = value
 }
 
+// GetJSON decodes the value stored with SetJSON under `key` into `value`. It
+// returns false if there is no value for `key`
+func (s *Session) GetJSON(key string, value interface{}) (bool, error) {
+	encoded := s.Get(key)
+	if encoded == "" {
+		return false, nil
+	}
+	return true, json.Unmarshal([]byte(encoded), value)
+}
+
+// SetJSON stores any value encoded as JSON under `key`
+func (s *Session) SetJSON(key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.Set(key, string(encoded))
+	return nil
+}
+
+// Delete removes the value stored under `key`
+func (s *Session) Delete(key string) {
+	session := s.GetSession()
+	s.modified = true
+	delete(session.Values, key)
+}
+
+// Clear removes all the values stored in the session
+func (s *Session) Clear() {
+	session := s.GetSession()
+	s.modified = true
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+}
+
+// Regenerate gives the session a new ID, keeping it's values, and drops it's
+// CSRF token so a new one is created. Auth calls it whenever privileges
+// change so a session ID planted before signing in is useless after
+func (s *Session) Regenerate() error {
+	session := s.GetSession()
+	s.modified = true
+	if store, ok := s.store.(*ServerSessionStore); ok {
+		if err := store.regenerate(session); err != nil {
+			return err
+		}
+	}
+	delete(session.Values, "csrfToken")
+	return nil
+}
+
+// RevokeUser deletes all the sessions of the given user, signing them out of
+// every device. It requires the 'db' session store
+func (s *Session) RevokeUser(userID string) error {
+	s.ensureStore()
+	if store, ok := s.store.(interface {
+		RevokeUser(userID string) error
+	}); ok {
+		return store.RevokeUser(userID)
+	}
+	return ErrorSessionRevokeUnsupported
+}
+
 func (s *Session) AddFlash(kind, message string) {
 	session := s.GetSession()
+	s.modified = true
 	session.AddFlash(&Flash{Kind: kind, Message: message})
 }
 
@@ -92,5 +213,8 @@ func (s *Session) Flashes() []*Flash {
 	for _, f := range session.Flashes() {
 		flashes = append(flashes, f.(*Flash))
 	}
+	if len(flashes) > 0 {
+		s.modified = true
+	}
 	return flashes
 }
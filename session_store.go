@@ -0,0 +1,257 @@
+package weeb
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// sessionStoreLock guards the lazy creation of App.SessionStore
+var sessionStoreLock sync.Mutex
+
+var ErrorSessionRevokeUnsupported = errors.New("Session store does not support revoking sessions")
+
+// sessionStore returns the app's session store, creating it from the
+// 'sessionStore' config ("cookie", "db" or "cache") the first time
+func (app *App) sessionStore() sessions.Store {
+	sessionStoreLock.Lock()
+	defer sessionStoreLock.Unlock()
+
+	if app.SessionStore != nil {
+		return app.SessionStore
+	}
+
+	secret := app.Config.Get("secret", "")
+	if secret == "" {
+		panic("Session: no 'secret' config is set. Use the 'generate-session-key' to generate one")
+	}
+	// Allow key rotations by splitting the secret config on the ','
+	keyPairs := [][]byte{}
+	for _, part := range strings.Split(secret, ",") {
+		keyPairs = append(keyPairs, []byte(part), nil)
+	}
+
+	storeType := app.Config.Get("sessionStore", "cookie")
+	switch storeType {
+	case "cookie":
+		store := sessions.NewCookieStore(keyPairs...)
+		// Also sets the codecs' MaxAge so cookies are decodable for as long
+		// as the browser keeps them
+		store.MaxAge(sessionMaxAge(app.Config))
+		app.SessionStore = store
+	case "db":
+		app.SessionStore = NewDBSessionStore(app.DB, keyPairs...)
+	case "cache":
+		app.SessionStore = NewCacheSessionStore(app.Cache, keyPairs...)
+	default:
+		panic("unknown session store type: " + storeType)
+	}
+	return app.SessionStore
+}
+
+// sessionMaxAge returns the 'sessionMaxAge' config, in seconds
+func sessionMaxAge(config *Config) int {
+	return config.GetInt("sessionMaxAge", 86400*7)
+}
+
+// sessionBackend is where a ServerSessionStore keeps session values
+type sessionBackend interface {
+	// load returns "" when no session exists for `id`
+	load(id string) (string, error)
+	save(id, userID, data string, expires time.Time) error
+	delete(id string) error
+}
+
+// ServerSessionStore is a session store keeping session values server side,
+// the cookie only holds the signed session ID. This lifts the 4KB limit of
+// cookie sessions and allows revoking sessions
+type ServerSessionStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	backend sessionBackend
+}
+
+var _ sessions.Store = sessions.Store(&ServerSessionStore{})
+
+// NewDBSessionStore creates a session store saving sessions in the
+// 'sessions' table of the given database
+func NewDBSessionStore(db DB, keyPairs ...[]byte) *ServerSessionStore {
+	return newServerSessionStore(&dbSessionBackend{db: db}, keyPairs)
+}
+
+// NewCacheSessionStore creates a session store saving sessions in the given
+// cache
+func NewCacheSessionStore(cache Cache, keyPairs ...[]byte) *ServerSessionStore {
+	return newServerSessionStore(&cacheSessionBackend{cache: cache}, keyPairs)
+}
+
+func newServerSessionStore(backend sessionBackend, keyPairs [][]byte) *ServerSessionStore {
+	s := &ServerSessionStore{
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{Path: "/", MaxAge: 86400 * 30},
+		backend: backend,
+	}
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			// Session values are not stored in a cookie so their size isn't limited
+			sc.MaxLength(0)
+			sc.MaxAge(0)
+		}
+	}
+	return s
+}
+
+// Get returns a cached session for the request or loads it
+func (s *ServerSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New creates a session, loading it's values if the request has a valid
+// session cookie
+func (s *ServerSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	data, err := s.backend.load(session.ID)
+	if err != nil || data == "" {
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, data, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save persists the session values and sets the session ID cookie. A
+// negative Options.MaxAge deletes the session
+func (s *ServerSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	maxAge := time.Duration(session.Options.MaxAge) * time.Second
+	if maxAge == 0 {
+		// Browser session cookie, keep values around for a day
+		maxAge = 24 * time.Hour
+	}
+	userID, _ := session.Values["userID"].(string)
+	if err := s.backend.save(session.ID, userID, data, time.Now().Add(maxAge)); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// regenerate deletes the session's stored values, Save stores them again
+// under a new ID
+func (s *ServerSessionStore) regenerate(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	if err := s.backend.delete(session.ID); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// RevokeUser deletes all the sessions of the given user. Only supported
+// by the database store
+func (s *ServerSessionStore) RevokeUser(userID string) error {
+	backend, ok := s.backend.(*dbSessionBackend)
+	if !ok {
+		return ErrorSessionRevokeUnsupported
+	}
+	return backend.db.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+}
+
+// DeleteExpired deletes expired sessions. Only needed for the database store
+// as cache entries expire on their own
+func (s *ServerSessionStore) DeleteExpired() error {
+	backend, ok := s.backend.(*dbSessionBackend)
+	if !ok {
+		return nil
+	}
+	return backend.db.Exec(`DELETE FROM sessions WHERE expires <= NOW()`)
+}
+
+type dbSessionBackend struct {
+	db DB
+}
+
+func (b *dbSessionBackend) load(id string) (string, error) {
+	data := ""
+	err := b.db.QueryOne(&data, `SELECT data FROM sessions WHERE id = $1 AND expires > NOW()`, id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return data, err
+}
+
+func (b *dbSessionBackend) save(id, userID, data string, expires time.Time) error {
+	return b.db.Exec(`
+		INSERT INTO sessions (id, user_id, data, expires, created, updated)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		ON CONFLICT (id) DO UPDATE SET user_id = $2, data = $3, expires = $4, updated = NOW()
+	`, id, userID, data, expires)
+}
+
+func (b *dbSessionBackend) delete(id string) error {
+	return b.db.Exec(`DELETE FROM sessions WHERE id = $1`, id)
+}
+
+type cacheSessionBackend struct {
+	cache Cache
+}
+
+func (b *cacheSessionBackend) load(id string) (string, error) {
+	data := ""
+	err := b.cache.Get("session:"+id, &data)
+	if err == CacheKeyNotFoundError {
+		return "", nil
+	}
+	return data, err
+}
+
+func (b *cacheSessionBackend) save(id, userID, data string, expires time.Time) error {
+	return b.cache.Set("session:"+id, data, time.Until(expires))
+}
+
+func (b *cacheSessionBackend) delete(id string) error {
+	return b.cache.Del("session:" + id)
+}
@@ -0,0 +1,123 @@
+package weeb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// newTestApp creates an App configured by the given APP_* environment
+// variables, with logging silenced
+func newTestApp(t *testing.T, env map[string]string) *App {
+	t.Setenv("APP_SECRET", "0123456789abcdef0123456789abcdef")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	app := NewApp()
+	app.Log.ClearOutputs(nil)
+	return app
+}
+
+// serve runs a request against the app's router, sending the given cookies
+func serve(app *App, req *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, req)
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+type testUser struct {
+	id string
+}
+
+func (u *testUser) AuthID() string       { return u.id }
+func (u *testUser) AuthUsername() string { return u.id }
+func (u *testUser) AuthPassword() string { return "" }
+func (u *testUser) AuthRoles() []string  { return []string{"user"} }
+
+func TestSigninUserRegeneratesSessionID(t *testing.T) {
+	app := newTestApp(t, map[string]string{"APP_SESSION_STORE": "cache"})
+	app.Auth.FindByID = func(ctx *Context, id string) (AuthUser, error) {
+		return &testUser{id: id}, nil
+	}
+	app.Router.Get("/visit/", func(ctx *Context) error {
+		ctx.Session.Set("cart", "42")
+		return ctx.Text(200, ctx.CSRFToken())
+	})
+	app.Router.Post("/signin/", func(ctx *Context) error {
+		ctx.Auth.SigninUser(ctx, &testUser{id: "1"})
+		return ctx.Text(200, ctx.CSRFToken())
+	})
+	app.Router.Get("/me/", func(ctx *Context) error {
+		return ctx.Text(200, ctx.Session.Get("userID")+" "+ctx.Session.Get("cart"))
+	})
+
+	w := serve(app, httptest.NewRequest("GET", "/visit/", nil))
+	before := responseCookie(w, "_app_session")
+	csrfBefore := w.Body.String()
+	if before == nil {
+		t.Fatal("expected a session cookie")
+	}
+
+	req := httptest.NewRequest("POST", "/signin/", nil)
+	req.Header.Set("X-CSRF-Token", csrfBefore)
+	w = serve(app, req, before)
+	after := responseCookie(w, "_app_session")
+	if w.Code != 200 || after == nil {
+		t.Fatalf("expected a new session cookie, got %d", w.Code)
+	}
+
+	var beforeID, afterID string
+	store := app.sessionStore().(*ServerSessionStore)
+	decode := func(cookie *http.Cookie, id *string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		session, _ := store.New(req, "_app_session")
+		*id = session.ID
+	}
+	decode(before, &beforeID)
+	decode(after, &afterID)
+	if beforeID == "" || beforeID == afterID {
+		t.Fatalf("expected the session ID to change, got %q and %q", beforeID, afterID)
+	}
+	if w.Body.String() == csrfBefore {
+		t.Fatal("expected a new CSRF token")
+	}
+
+	if w := serve(app, httptest.NewRequest("GET", "/me/", nil), before); w.Body.String() != " " {
+		t.Fatalf("expected the old session to be gone, got %q", w.Body.String())
+	}
+	if w := serve(app, httptest.NewRequest("GET", "/me/", nil), after); w.Body.String() != "1 42" {
+		t.Fatalf("expected the new session to keep it's values, got %q", w.Body.String())
+	}
+}
+
+func TestCookieSessionMaxAgeAppliesToCodecs(t *testing.T) {
+	app := newTestApp(t, map[string]string{"APP_SESSION_MAX_AGE": "7776000"})
+	store := app.sessionStore().(*sessions.CookieStore)
+	if store.Options.MaxAge != 7776000 {
+		t.Fatalf("expected a MaxAge of 90 days, got %d", store.Options.MaxAge)
+	}
+
+	for _, codec := range store.Codecs {
+		// securecookie doesn't expose it's MaxAge
+		maxAge := reflect.ValueOf(codec).Elem().FieldByName("maxAge").Int()
+		if maxAge != 7776000 {
+			t.Fatalf("expected the codecs' MaxAge to be 90 days, got %d", maxAge)
+		}
+	}
+}
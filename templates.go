@@ -10,6 +10,27 @@ var TemplatesFunctionMap = template.FuncMap{
 	"date":        func(t time.Time) string { return t.Format("2006-01-02") },
 	"datetime":    func(t time.Time) string { return t.Format("2006-01-02 15:04") },
 	"datetimesec": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"csrfField":   csrfField,
+	"csrfToken":   csrfToken,
+	"can":         can,
+	"fieldError":  fieldError,
+	"t":           translate,
+}
+
+// fieldError is the 'fieldError' template function returning the first error
+// message of a field set by ctx.ValidationError, `{{ fieldError . "email" }}`
+func fieldError(data interface{}, fieldName string) string {
+	var fields map[string][]ValidationError
+	switch values := data.(type) {
+	case J:
+		fields, _ = values["errors"].(map[string][]ValidationError)
+	case map[string]interface{}:
+		fields, _ = values["errors"].(map[string][]ValidationError)
+	}
+	if len(fields[fieldName]) == 0 {
+		return ""
+	}
+	return fields[fieldName][0].Message
 }
 
 type Templates interface {
@@ -0,0 +1,109 @@
+package weeb
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrorAccountLocked = errors.New("Account is temporarily locked")
+
+// signinThrottle limits failed signins by username and by client IP. After
+// too many failures in 'signinWindow' the username or IP is locked out for
+// 'signinLockout'. Counters live in App.Cache. Password and second factor
+// failures are counted under separate keys for the username, the IP's are
+// shared
+type signinThrottle struct {
+	ctx      *Context
+	kind     string
+	username string
+	ip       string
+}
+
+func newSigninThrottle(ctx *Context, username string) *signinThrottle {
+	return &signinThrottle{
+		ctx:      ctx,
+		kind:     "user",
+		username: strings.ToLower(strings.TrimSpace(username)),
+		ip:       ctx.ClientIP(),
+	}
+}
+
+// newSecondFactorThrottle creates a throttle for TOTP and recovery codes
+func newSecondFactorThrottle(ctx *Context, username string) *signinThrottle {
+	throttle := newSigninThrottle(ctx, username)
+	throttle.kind = "2fa"
+	return throttle
+}
+
+func (t *signinThrottle) keys() map[string]int {
+	keys := map[string]int{}
+	if t.username != "" {
+		keys["signin:"+t.kind+":"+t.username] = t.ctx.Config.GetInt("signinMaxAttempts", 5)
+	}
+	if t.ip != "" {
+		keys["signin:ip:"+t.ip] = t.ctx.Config.GetInt("signinMaxAttemptsIp", 20)
+	}
+	return keys
+}
+
+// locked returns ErrorAccountLocked if the username or IP is locked out
+func (t *signinThrottle) locked() error {
+	for key := range t.keys() {
+		locked := false
+		err := t.ctx.Cache.Get(key+":locked", &locked)
+		if err == nil && locked {
+			t.ctx.Log.Warning("signin locked", L{"username": t.username, "ip": t.ip})
+			return ErrorAccountLocked
+		}
+		if err != nil && err != CacheKeyNotFoundError {
+			return err
+		}
+	}
+	return nil
+}
+
+// failed records a failed attempt, locking out the username or IP when
+// they reach their limit. The window starts at the first failure
+func (t *signinThrottle) failed(reason string) error {
+	window := t.ctx.Config.GetDuration("signinWindow", 15*time.Minute)
+	lockout := t.ctx.Config.GetDuration("signinLockout", 15*time.Minute)
+
+	fields := L{"username": t.username, "ip": t.ip, "reason": reason}
+	locked := []string{}
+	for key, maxAttempts := range t.keys() {
+		// Incr is atomic so concurrent guesses are all counted
+		count, err := t.ctx.Cache.Incr(key+":count", window)
+		if err != nil {
+			return err
+		}
+		fields[strings.Split(key, ":")[1]+"Attempts"] = count
+
+		if maxAttempts > 0 && count >= int64(maxAttempts) {
+			if err := t.ctx.Cache.Set(key+":locked", true, lockout); err != nil {
+				return err
+			}
+			if err := t.ctx.Cache.Del(key + ":count"); err != nil {
+				return err
+			}
+			locked = append(locked, key)
+		}
+	}
+
+	t.ctx.Log.Warning("signin failed", fields)
+	if len(locked) > 0 {
+		fields["locked"] = strings.Join(locked, ",")
+		fields["lockout"] = lockout.String()
+		t.ctx.Log.Warning("account locked", fields)
+	}
+	return nil
+}
+
+// succeeded resets the username's failed attempts, the IP's are kept so
+// signing in to an owned account doesn't reset guessing others
+func (t *signinThrottle) succeeded() error {
+	if t.username == "" {
+		return nil
+	}
+	return t.ctx.Cache.Del("signin:" + t.kind + ":" + t.username + ":count")
+}
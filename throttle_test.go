@@ -0,0 +1,94 @@
+package weeb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testPasswordUser struct {
+	testTOTPUser
+	hash string
+}
+
+func (u *testPasswordUser) AuthPassword() string { return u.hash }
+
+func TestSigninThrottleWaitsForSecondFactor(t *testing.T) {
+	app := newTestApp(t, nil)
+	hash, err := app.Auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &testPasswordUser{testTOTPUser{testUser{id: "1"}}, hash}
+	app.Auth.FindByUsername = func(ctx *Context, username string) (AuthUser, error) {
+		return user, nil
+	}
+	app.Auth.FindByID = func(ctx *Context, id string) (AuthUser, error) {
+		return user, nil
+	}
+	app.Router.Get("/signin/", func(ctx *Context) error {
+		err := ctx.Auth.Signin(ctx, AuthSigninInfo{Username: "1", Password: ctx.Param("password", "")})
+		return ctx.Text(200, errorText(err))
+	})
+	app.Router.Get("/two-factor/", func(ctx *Context) error {
+		return ctx.Text(200, errorText(ctx.Auth.VerifySecondFactor(ctx, ctx.Param("code", ""))))
+	})
+
+	var cookie *http.Cookie
+	request := func(path string) string {
+		cookies := []*http.Cookie{}
+		if cookie != nil {
+			cookies = append(cookies, cookie)
+		}
+		w := serve(app, httptest.NewRequest("GET", path, nil), cookies...)
+		if c := responseCookie(w, "_app_session"); c != nil {
+			cookie = c
+		}
+		return w.Body.String()
+	}
+	attempts := func(kind string) int64 {
+		var count int64
+		if err := app.Cache.Get("signin:"+kind+":1:count", &count); err != nil && err != CacheKeyNotFoundError {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	for i := 0; i < 4; i++ {
+		if result := request("/signin/?password=wrong"); result != ErrorPasswordsDontMatch.Error() {
+			t.Fatalf("expected a wrong password, got %q", result)
+		}
+	}
+	if result := request("/signin/?password=" + url.QueryEscape("correct horse")); result != ErrorSecondFactorRequired.Error() {
+		t.Fatalf("expected a second factor to be required, got %q", result)
+	}
+	if count := attempts("user"); count != 4 {
+		t.Fatalf("expected the password attempts to be kept until the second factor, got %d", count)
+	}
+
+	for i := 0; i < 2; i++ {
+		if result := request("/two-factor/?code="); result != ErrorSecondFactorInvalid.Error() {
+			t.Fatalf("expected an invalid second factor, got %q", result)
+		}
+	}
+	if user, twoFactor := attempts("user"), attempts("2fa"); user != 4 || twoFactor != 2 {
+		t.Fatalf("expected 4 password and 2 second factor attempts, got %d and %d", user, twoFactor)
+	}
+
+	code, _ := TOTPCode(user.AuthTOTPSecret(), time.Now())
+	if result := request("/two-factor/?code=" + code); result != "" {
+		t.Fatalf("expected the second factor to be accepted, got %q", result)
+	}
+	if user, twoFactor := attempts("user"), attempts("2fa"); user != 0 || twoFactor != 0 {
+		t.Fatalf("expected the attempts to be reset, got %d and %d", user, twoFactor)
+	}
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,239 @@
+package weeb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorSecondFactorRequired = errors.New("Second factor required")
+var ErrorSecondFactorInvalid = errors.New("Second factor code is invalid")
+var ErrorNoPendingSignin = errors.New("No signin is waiting for a second factor")
+
+const totpPeriod = 30
+const totpDigits = 6
+
+// AuthTOTPUser is implemented by users that can have two-factor
+// authentication enabled. An empty secret means it's disabled
+type AuthTOTPUser interface {
+	AuthTOTPSecret() string
+}
+
+// GenerateTOTPSecret generates a new base32 encoded TOTP secret
+func GenerateTOTPSecret() string {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps read from QR codes
+func TOTPURI(secret, issuer, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode computes the RFC 6238 code for the given secret and time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, t.Unix()/totpPeriod)
+}
+
+// ValidateTOTP checks the given code against the codes for the `skew` time
+// steps around `t` and returns the matching time step
+func ValidateTOTP(secret, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpPeriod
+	for i := -int64(skew); i <= int64(skew); i++ {
+		expected, err := totpCode(secret, now+i)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return now + i, true
+		}
+	}
+	return 0, false
+}
+
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(
+		strings.ToUpper(strings.TrimRight(strings.Replace(secret, " ", "", -1), "=")))
+	if err != nil {
+		return "", err
+	}
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+func totpSecret(user AuthUser) string {
+	if totpUser, ok := user.(AuthTOTPUser); ok {
+		return totpUser.AuthTOTPSecret()
+	}
+	return ""
+}
+
+// PendingUser returns the user that entered a valid password but still needs
+// to provide a second factor, if any
+func (a *Auth) PendingUser(ctx *Context) (AuthUser, error) {
+	userID := ctx.Session.Get("pendingUserID")
+	if userID == "" {
+		return nil, nil
+	}
+	return a.FindByID(ctx, userID)
+}
+
+// VerifySecondFactor checks the TOTP or recovery code of the pending user and
+// signs them in when it's valid
+func (a *Auth) VerifySecondFactor(ctx *Context, code string) error {
+	user, err := a.PendingUser(ctx)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrorNoPendingSignin
+	}
+
+	if err := a.CheckSecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+	if err := newSigninThrottle(ctx, user.AuthUsername()).succeeded(); err != nil {
+		return err
+	}
+
+	ctx.Session.Delete("pendingUserID")
+	a.SigninUser(ctx, user)
+	return nil
+}
+
+// CheckSecondFactor checks a TOTP or recovery code of the user without
+// signing them in, e.g. before disabling two-factor authentication. It
+// returns ErrorSecondFactorInvalid or ErrorAccountLocked
+func (a *Auth) CheckSecondFactor(ctx *Context, user AuthUser, code string) error {
+	// Codes are short, guessing them is throttled like passwords are
+	throttle := newSecondFactorThrottle(ctx, user.AuthUsername())
+	if err := throttle.locked(); err != nil {
+		return err
+	}
+	ok, err := a.checkSecondFactor(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := throttle.failed("invalid second factor"); err != nil {
+			return err
+		}
+		return ErrorSecondFactorInvalid
+	}
+	return throttle.succeeded()
+}
+
+// VerifyTOTP checks a TOTP code for the given secret, refusing codes that
+// were already used by the user
+func (a *Auth) VerifyTOTP(ctx *Context, user AuthUser, secret, code string) (bool, error) {
+	skew := ctx.Config.GetInt("totpSkew", 1)
+	counter, ok := ValidateTOTP(secret, code, time.Now(), skew)
+	if !ok {
+		return false, nil
+	}
+
+	// Remember the last time step used so older codes are refused too
+	key := "totp:" + user.AuthID()
+	var lastCounter int64
+	err := ctx.Cache.Get(key, &lastCounter)
+	if err != nil && err != CacheKeyNotFoundError {
+		return false, err
+	}
+	if err == nil && counter <= lastCounter {
+		return false, nil
+	}
+	// Only the first of concurrent requests using a code gets a count of 1
+	ttl := time.Duration((2*skew+1)*totpPeriod) * time.Second
+	uses, err := ctx.Cache.Incr(key+":"+strconv.FormatInt(counter, 10), ttl)
+	if err != nil || uses != 1 {
+		return false, err
+	}
+	return true, ctx.Cache.Set(key, counter, ttl)
+}
+
+func (a *Auth) checkSecondFactor(ctx *Context, user AuthUser, code string) (bool, error) {
+	if secret := totpSecret(user); secret != "" {
+		ok, err := a.VerifyTOTP(ctx, user, secret, code)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return a.useRecoveryCode(ctx, user, code)
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes with 10 new ones.
+// Each can be used once instead of a TOTP code
+func (a *Auth) GenerateRecoveryCodes(ctx *Context, user AuthUser) ([]string, error) {
+	err := ctx.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, user.AuthID())
+	if err != nil {
+		return nil, err
+	}
+	codes := []string{}
+	for i := 0; i < 10; i++ {
+		code := strings.ToLower(generateRandomKey(10))
+		err := ctx.DB.Exec(`
+			INSERT INTO recovery_codes (code_hash, user_id, created) VALUES ($1, $2, NOW())
+		`, hashToken(code), user.AuthID())
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	ctx.Log.Info("recovery codes generated", L{"userID": user.AuthID()})
+	return codes, nil
+}
+
+// DeleteRecoveryCodes deletes the user's recovery codes, for example when
+// two-factor authentication is disabled
+func (a *Auth) DeleteRecoveryCodes(ctx *Context, user AuthUser) error {
+	return ctx.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, user.AuthID())
+}
+
+func (a *Auth) useRecoveryCode(ctx *Context, user AuthUser, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return false, nil
+	}
+	var used int64
+	err := ctx.DB.QueryOne(&used, `
+		WITH deleted AS (DELETE FROM recovery_codes WHERE code_hash = $1 AND user_id = $2 RETURNING 1)
+		SELECT COUNT(*) FROM deleted
+	`, hashToken(code), user.AuthID())
+	if err != nil {
+		return false, err
+	}
+	if used > 0 {
+		ctx.Log.Info("recovery code used", L{"userID": user.AuthID()})
+	}
+	return used > 0, nil
+}
@@ -0,0 +1,151 @@
+package weeb
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withTestContext runs fn with the context of a request to the app
+func withTestContext(app *App, fn func(ctx *Context)) {
+	app.Router.Get("/test-context/", func(ctx *Context) error {
+		fn(ctx)
+		return ctx.Text(200, "")
+	})
+	serve(app, httptest.NewRequest("GET", "/test-context/", nil))
+}
+
+func TestVerifyTOTPRefusesReplays(t *testing.T) {
+	app := newTestApp(t, nil)
+	user := &testTOTPUser{testUser{id: "1"}}
+	code, _ := TOTPCode(user.AuthTOTPSecret(), time.Now())
+
+	withTestContext(app, func(ctx *Context) {
+		accepted := 0
+		mutex := sync.Mutex{}
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := ctx.Auth.VerifyTOTP(ctx, user, user.AuthTOTPSecret(), code)
+				if err != nil {
+					t.Error(err)
+				}
+				if ok {
+					mutex.Lock()
+					accepted++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if accepted != 1 {
+			t.Fatalf("expected the code to be accepted once, got %d", accepted)
+		}
+	})
+}
+
+func TestCheckSecondFactorIsThrottled(t *testing.T) {
+	app := newTestApp(t, nil)
+	user := &testTOTPUser{testUser{id: "1"}}
+
+	withTestContext(app, func(ctx *Context) {
+		for i := 0; i < 5; i++ {
+			if err := ctx.Auth.CheckSecondFactor(ctx, user, ""); err != ErrorSecondFactorInvalid {
+				t.Fatalf("expected an invalid code, got %v", err)
+			}
+		}
+		code, _ := TOTPCode(user.AuthTOTPSecret(), time.Now())
+		if err := ctx.Auth.CheckSecondFactor(ctx, user, code); err != ErrorAccountLocked {
+			t.Fatalf("expected the account to be locked, got %v", err)
+		}
+	})
+}
+
+// The RFC 6238 appendix B SHA1 vectors, truncated to 6 digits. The secret
+// is the ASCII "12345678901234567890"
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil || code != expected {
+			t.Fatalf("expected %s at %d, got %s (%v)", expected, unix, code, err)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	previous, _ := TOTPCode(secret, now.Add(-totpPeriod*time.Second))
+	next, _ := TOTPCode(secret, now.Add(totpPeriod*time.Second))
+	old, _ := TOTPCode(secret, now.Add(-2*totpPeriod*time.Second))
+
+	if counter, ok := ValidateTOTP(secret, previous, now, 1); !ok || counter != step-1 {
+		t.Fatalf("expected the previous step's code within a skew of 1, got %d %v", counter, ok)
+	}
+	if counter, ok := ValidateTOTP(secret, next, now, 1); !ok || counter != step+1 {
+		t.Fatalf("expected the next step's code within a skew of 1, got %d %v", counter, ok)
+	}
+	if _, ok := ValidateTOTP(secret, previous, now, 0); ok {
+		t.Fatal("expected the previous step's code to be refused without skew")
+	}
+	if _, ok := ValidateTOTP(secret, old, now, 1); ok {
+		t.Fatal("expected a code two steps old to be refused with a skew of 1")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 1); ok {
+		t.Fatal("expected a code of the wrong length to be refused")
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	app := newTestApp(t, nil)
+	db := &recordingDB{}
+	stored := map[string]bool{}
+	db.OnExec = func(query string, args []interface{}) error {
+		if strings.Contains(query, "INSERT INTO recovery_codes") {
+			stored[args[0].(string)] = true
+		}
+		return nil
+	}
+	db.OnQueryOne = func(dest interface{}, query string, args []interface{}) error {
+		// DELETE ... RETURNING, counting the deleted codes
+		used := stored[args[0].(string)]
+		delete(stored, args[0].(string))
+		*dest.(*int64) = 0
+		if used {
+			*dest.(*int64) = 1
+		}
+		return nil
+	}
+	app.DB = db
+	user := &testTOTPUser{testUser{id: "1"}}
+
+	withTestContext(app, func(ctx *Context) {
+		codes, err := ctx.Auth.GenerateRecoveryCodes(ctx, user)
+		if err != nil || len(codes) != 10 {
+			t.Fatalf("expected 10 codes, got %v (%v)", codes, err)
+		}
+		if ok, err := ctx.Auth.checkSecondFactor(ctx, user, strings.ToUpper(codes[0])); !ok || err != nil {
+			t.Fatalf("expected the recovery code to be accepted, got %v (%v)", ok, err)
+		}
+		if ok, _ := ctx.Auth.checkSecondFactor(ctx, user, codes[0]); ok {
+			t.Fatal("expected a used recovery code to be refused")
+		}
+		if ok, _ := ctx.Auth.checkSecondFactor(ctx, user, codes[1]); !ok {
+			t.Fatal("expected the other recovery codes to still be usable")
+		}
+	})
+}
@@ -3,7 +3,9 @@ package weeb
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strconv"
@@ -166,6 +168,13 @@ func generateRandomKey(length int) string {
 	return string(key)
 }
 
+// hashToken hashes a random token for storage. Tokens have enough entropy
+// that a fast hash is fine, unlike passwords
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
 // from golang/src/net/http/http.go:62
 func hexEscapeNonASCII(s string) string {
 	newLen := 0
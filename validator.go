@@ -1,33 +1,110 @@
 package weeb
 
 import (
+	"crypto/subtle"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+var validatorEmailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+var validatorNumericRegexp = regexp.MustCompile(`^[-+]?[0-9]*\.?[0-9]+$`)
+var validatorAlphanumericRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
 // ValidationFn represents a check the validator can do again some arbitrary input.
 // Here fieldName's main use is for nice human readable error messages.
-type ValidationFn func(ctx *Context, fieldName string, value string) []string
+type ValidationFn func(ctx *Context, fieldName string, value string) []ValidationError
+
+// ValidationMessagesFn is a check returning plain error messages, like
+// ValidationFns did before errors had codes
+type ValidationMessagesFn func(ctx *Context, fieldName string, value string) []string
+
+// ValidateMessages adapts a ValidationMessagesFn to a ValidationFn, each
+// message becomes an error with the "invalid" code
+func ValidateMessages(fn ValidationMessagesFn) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		errors := []ValidationError{}
+		for _, message := range fn(ctx, fieldName, value) {
+			errors = append(errors, NewValidationError(fieldName, "invalid", nil, message))
+		}
+		return errors
+	}
+}
+
+// ValidationError is a failed check on a field. Code identifies the check
+// (e.g. "required", "min") and Params holds it's arguments so clients can
+// build their own messages
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Params  J      `json:"params,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error returns the error's human readable message
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// NewValidationError creates a validation error for use in ValidationFns
+func NewValidationError(fieldName, code string, params J, message string) ValidationError {
+	return ValidationError{Field: fieldName, Code: code, Params: params, Message: message}
+}
 
 // Validator represents an instance of a validator and hold the errors that occurred.
 type Validator struct {
 	ctx    *Context
-	errors []string
+	errors []ValidationError
 }
 
 // NewValidator creates a new validator linked to a specific context. The
 // context is required for more advanced validators that need to access the
 // database or app configuration.
 func NewValidator(ctx *Context) *Validator {
-	return &Validator{ctx: ctx, errors: []string{}}
+	return &Validator{ctx: ctx, errors: []ValidationError{}}
 }
 
 // Validate validates part of the input using a set of provided validation functions
 func (v *Validator) Validate(fieldName string, value string, validations []ValidationFn) {
 	for _, validation := range validations {
-		errors := validation(v.ctx, fieldName, value)
-		v.errors = append(v.errors, errors...)
+		for _, err := range validation(v.ctx, fieldName, value) {
+			v.errors = append(v.errors, v.translate(err))
+		}
+	}
+}
+
+// AddError adds an error for checks done outside of a ValidationFn
+func (v *Validator) AddError(fieldName, code string, params J, message string) {
+	v.errors = append(v.errors, v.translate(NewValidationError(fieldName, code, params, message)))
+}
+
+// translate replaces the error's message by the 'validation.<code>' message
+// of the request's locale when there is one. Messages get the field's
+// 'fields.<name>' message as '{field}' and the error's params
+func (v *Validator) translate(err ValidationError) ValidationError {
+	if v.ctx == nil || v.ctx.app == nil || v.ctx.app.I18n == nil {
+		return err
+	}
+	i18n, locale := v.ctx.app.I18n, v.ctx.Locale()
+	if !i18n.Has(locale, "validation."+err.Code) {
+		return err
+	}
+
+	params := J{"field": title(err.Field)}
+	if i18n.Has(locale, "fields."+err.Field) {
+		params["field"] = i18n.Translate(locale, "fields."+err.Field, nil)
+	}
+	for name, value := range err.Params {
+		if values, ok := value.([]string); ok {
+			value = strings.Join(values, ", ")
+		}
+		params[name] = value
 	}
+	err.Message = i18n.Translate(locale, "validation."+err.Code, params)
+	return err
 }
 
 // Valid returns true if the validator encountered no validation errors
@@ -35,28 +112,41 @@ func (v *Validator) Valid() bool {
 	return len(v.errors) == 0
 }
 
-// Errors returns the list of validation errors that occurred
+// Errors returns the list of validation error messages
 func (v *Validator) Errors() []string {
-	return v.errors
+	messages := []string{}
+	for _, err := range v.errors {
+		messages = append(messages, err.Message)
+	}
+	return messages
+}
+
+// FieldErrors returns the validation errors that occurred keyed by field name
+func (v *Validator) FieldErrors() map[string][]ValidationError {
+	fields := map[string][]ValidationError{}
+	for _, err := range v.errors {
+		fields[err.Field] = append(fields[err.Field], err)
+	}
+	return fields
 }
 
 // ValidatePresence validates the input is a non-empty string
 func ValidatePresence() ValidationFn {
-	return func(ctx *Context, fieldName string, value string) []string {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
 		if len(value) == 0 {
-			return []string{title(fieldName) + " is a required field"}
+			return []ValidationError{NewValidationError(fieldName, "required", nil, title(fieldName)+" is a required field")}
 		}
-		return []string{}
+		return []ValidationError{}
 	}
 }
 
 // ValidateRegexp validates that the input matches a given regular expression
 func ValidateRegexp(r *regexp.Regexp) ValidationFn {
-	return func(ctx *Context, fieldName string, value string) []string {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
 		if !r.Match([]byte(value)) {
-			return []string{title(fieldName) + " is not in the valid format"}
+			return []ValidationError{NewValidationError(fieldName, "format", nil, title(fieldName)+" is not in the valid format")}
 		}
-		return []string{}
+		return []ValidationError{}
 	}
 }
 
@@ -64,18 +154,191 @@ func ValidateRegexp(r *regexp.Regexp) ValidationFn {
 // A min of -1 will be ignored. A max of -1 will be ignored. Min and max are
 // inclusive so ValidateLength(1,3) passes [1,2,3] but fails [0,4,...]
 func ValidateLength(min int, max int) ValidationFn {
-	return func(ctx *Context, fieldName string, value string) []string {
-		errors := []string{}
-		if min != -1 {
-			if len(value) < min {
-				errors = append(errors, title(fieldName)+" is shorter than "+strconv.Itoa(min))
-			}
-		}
-		if max != -1 {
-			if len(value) > max {
-				errors = append(errors, title(fieldName)+" is longer than "+strconv.Itoa(min))
-			}
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		return validateLength(fieldName, len(value), min, max)
+	}
+}
+
+func validateLength(fieldName string, length, min, max int) []ValidationError {
+	errors := []ValidationError{}
+	if min != -1 {
+		if length < min {
+			errors = append(errors, NewValidationError(fieldName, "min", J{"min": min},
+				title(fieldName)+" is shorter than "+strconv.Itoa(min)))
 		}
-		return errors
 	}
+	if max != -1 {
+		if length > max {
+			errors = append(errors, NewValidationError(fieldName, "max", J{"max": max},
+				title(fieldName)+" is longer than "+strconv.Itoa(max)))
+		}
+	}
+	return errors
+}
+
+// ValidateEmail validates the input looks like an email address
+func ValidateEmail() ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		if !validatorEmailRegexp.MatchString(value) {
+			return []ValidationError{NewValidationError(fieldName, "email", nil, title(fieldName)+" is not a valid email address")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateNumeric validates the input is a number
+func ValidateNumeric() ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		if !validatorNumericRegexp.MatchString(value) {
+			return []ValidationError{NewValidationError(fieldName, "numeric", nil, title(fieldName)+" is not a number")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateAlphanumeric validates the input only contains letters and digits
+func ValidateAlphanumeric() ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		if !validatorAlphanumericRegexp.MatchString(value) {
+			return []ValidationError{NewValidationError(fieldName, "alphanum", nil, title(fieldName)+" can only contain letters and digits")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateOneOf validates the input is one of the given options
+func ValidateOneOf(options ...string) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		if !containsString(options, value) {
+			return []ValidationError{NewValidationError(fieldName, "oneof", J{"options": options},
+				title(fieldName)+" must be one of "+strings.Join(options, ", "))}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateRuneLength works like ValidateLength but counts characters instead
+// of bytes, "é" has a length of 1
+func ValidateRuneLength(min int, max int) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		return validateLength(fieldName, utf8.RuneCountInString(value), min, max)
+	}
+}
+
+// ValidateURL validates the input is an absolute http or https URL
+func ValidateURL() ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return []ValidationError{NewValidationError(fieldName, "url", nil, title(fieldName)+" is not a valid URL")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateRange validates the input is a number within certain bounds. Min
+// and max are inclusive
+func ValidateRange(min float64, max float64) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return []ValidationError{NewValidationError(fieldName, "numeric", nil, title(fieldName)+" is not a number")}
+		}
+		if number < min || number > max {
+			return []ValidationError{NewValidationError(fieldName, "range", J{"min": min, "max": max},
+				title(fieldName)+" must be between "+formatFloat(min)+" and "+formatFloat(max))}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateMin validates the input is a number greater than or equal to min
+func ValidateMin(min float64) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return []ValidationError{NewValidationError(fieldName, "numeric", nil, title(fieldName)+" is not a number")}
+		}
+		if number < min {
+			return []ValidationError{NewValidationError(fieldName, "minvalue", J{"min": min},
+				title(fieldName)+" must be at least "+formatFloat(min))}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateMax validates the input is a number less than or equal to max
+func ValidateMax(max float64) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return []ValidationError{NewValidationError(fieldName, "numeric", nil, title(fieldName)+" is not a number")}
+		}
+		if number > max {
+			return []ValidationError{NewValidationError(fieldName, "maxvalue", J{"max": max},
+				title(fieldName)+" must be at most "+formatFloat(max))}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateDate validates the input is a date in the given time.Parse layout
+func ValidateDate(layout string) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		if _, err := time.Parse(layout, value); err != nil {
+			return []ValidationError{NewValidationError(fieldName, "date", J{"layout": layout},
+				title(fieldName)+" is not a valid date")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateConfirmation validates the input matches the value of the field it
+// confirms, e.g. ValidateConfirmation(password) for 'passwordConfirmation'
+func ValidateConfirmation(expected string) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(expected)) != 1 {
+			return []ValidationError{NewValidationError(fieldName, "confirmation", nil, title(fieldName)+" doesn't match")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateUnique validates no row of `table` has the input as `column`. Pass
+// the ID of the row being edited as `exceptID`, or "" when creating one
+func ValidateUnique(table, column, exceptID string) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		count, err := ctx.DBHelper.Count(table, map[string]interface{}{column: value}, exceptID)
+		if err != nil {
+			return validationDBError(ctx, fieldName, err)
+		}
+		if count > 0 {
+			return []ValidationError{NewValidationError(fieldName, "unique", nil, title(fieldName)+" is already taken")}
+		}
+		return []ValidationError{}
+	}
+}
+
+// ValidateExists validates a row of `table` has the input as `column`, for
+// example to check a foreign key
+func ValidateExists(table, column string) ValidationFn {
+	return func(ctx *Context, fieldName string, value string) []ValidationError {
+		count, err := ctx.DBHelper.Count(table, map[string]interface{}{column: value}, "")
+		if err != nil {
+			return validationDBError(ctx, fieldName, err)
+		}
+		if count == 0 {
+			return []ValidationError{NewValidationError(fieldName, "exists", nil, title(fieldName)+" does not exist")}
+		}
+		return []ValidationError{}
+	}
+}
+
+func validationDBError(ctx *Context, fieldName string, err error) []ValidationError {
+	ctx.Log.Error("error validating field", L{"field": fieldName, "err": err.Error()})
+	return []ValidationError{NewValidationError(fieldName, "error", nil, title(fieldName)+" could not be validated")}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
 }
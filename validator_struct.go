@@ -0,0 +1,215 @@
+package weeb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ValidationRule builds the ValidationFn for a `validate` struct tag rule
+// from the rule's parameter (`3` in `min=3`)
+type ValidationRule func(param string) (ValidationFn, error)
+
+// ValidationRules are the rules usable in `validate` struct tags. Apps can
+// register their own
+var ValidationRules = map[string]ValidationRule{
+	"required": func(param string) (ValidationFn, error) {
+		return ValidatePresence(), nil
+	},
+	"min": func(param string) (ValidationFn, error) {
+		min, err := strconv.Atoi(param)
+		return ValidateRuneLength(min, -1), err
+	},
+	"max": func(param string) (ValidationFn, error) {
+		max, err := strconv.Atoi(param)
+		return ValidateRuneLength(-1, max), err
+	},
+	"range": func(param string) (ValidationFn, error) {
+		bounds := strings.SplitN(param, ":", 2)
+		if len(bounds) != 2 {
+			return nil, errors.New("expected range=min:max")
+		}
+		min, err := strconv.ParseFloat(bounds[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		max, err := strconv.ParseFloat(bounds[1], 64)
+		return ValidateRange(min, max), err
+	},
+	"url": func(param string) (ValidationFn, error) {
+		return ValidateURL(), nil
+	},
+	"date": func(param string) (ValidationFn, error) {
+		return ValidateDate(OrString(param, "2006-01-02")), nil
+	},
+	// ValidateStruct replaces the param of "confirms" with the value of the
+	// field it names
+	"confirms": func(param string) (ValidationFn, error) {
+		return ValidateConfirmation(param), nil
+	},
+	"unique": func(param string) (ValidationFn, error) {
+		parts := strings.SplitN(param, ".", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("expected unique=table.column")
+		}
+		return ValidateUnique(parts[0], parts[1], ""), nil
+	},
+	"exists": func(param string) (ValidationFn, error) {
+		parts := strings.SplitN(param, ".", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("expected exists=table.column")
+		}
+		return ValidateExists(parts[0], parts[1]), nil
+	},
+	"email": func(param string) (ValidationFn, error) {
+		return ValidateEmail(), nil
+	},
+	"numeric": func(param string) (ValidationFn, error) {
+		return ValidateNumeric(), nil
+	},
+	"alphanum": func(param string) (ValidationFn, error) {
+		return ValidateAlphanumeric(), nil
+	},
+	"oneof": func(param string) (ValidationFn, error) {
+		return ValidateOneOf(strings.Fields(param)...), nil
+	},
+}
+
+// validationNumberRules replace ValidationRules of the same name for number
+// fields, "min" and "max" bound the value instead of it's length
+var validationNumberRules = map[string]ValidationRule{
+	"min": func(param string) (ValidationFn, error) {
+		min, err := strconv.ParseFloat(param, 64)
+		return ValidateMin(min), err
+	},
+	"max": func(param string) (ValidationFn, error) {
+		max, err := strconv.ParseFloat(param, 64)
+		return ValidateMax(max), err
+	},
+}
+
+// ValidateStruct validates the fields of the struct `s` points to using their
+// `validate` tags, for example:
+//
+//	Name                 string `validate:"required,min=3,max=50"`
+//	Email                string `validate:"required,email,unique=users.email"`
+//	Role                 string `validate:"oneof=admin user"`
+//	Age                  int    `validate:"range=13:130"`
+//	Password             string `validate:"required,min=8"`
+//	PasswordConfirmation string `validate:"confirms=password"`
+//
+// Rules other than "required" and "confirms" are skipped for empty strings,
+// nil pointers and zero values of non number types, "required" also fails
+// for zero numbers. "min" and "max" count characters, or bound the value of
+// number fields.
+// Unexported fields are ignored. Errors are keyed by the field's form name,
+// its `mapstructure` or `json` tag if set
+func (v *Validator) ValidateStruct(s interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(s))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("Validator: expected a struct, got %s", value.Kind())
+	}
+
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		fieldName := validationFieldName(field)
+		fieldValue, zero := validationFieldValue(value.Field(i))
+		empty := fieldValue == "" || (zero && !validationIsNumber(field.Type))
+		validations := []ValidationFn{}
+		for _, rule := range strings.Split(tag, ",") {
+			name, param := rule, ""
+			if index := strings.Index(rule, "="); index != -1 {
+				name, param = rule[:index], rule[index+1:]
+			}
+			if name != "required" && name != "confirms" && empty {
+				continue
+			}
+			if name == "confirms" {
+				other, ok := validationField(value, param)
+				if !ok {
+					return fmt.Errorf("Validator: unknown field '%s' confirmed by %s", param, field.Name)
+				}
+				param, _ = validationFieldValue(other)
+			}
+			build, ok := ValidationRules[name]
+			if numberRule, isNumberRule := validationNumberRules[name]; isNumberRule && validationIsNumber(field.Type) {
+				build, ok = numberRule, true
+			}
+			if !ok {
+				return fmt.Errorf("Validator: unknown rule '%s' on field %s", name, field.Name)
+			}
+			validation, err := build(param)
+			if err != nil {
+				return fmt.Errorf("Validator: invalid rule '%s' on field %s: %s", rule, field.Name, err.Error())
+			}
+			if name == "required" && zero {
+				v.Validate(fieldName, "", []ValidationFn{validation})
+				continue
+			}
+			validations = append(validations, validation)
+		}
+		v.Validate(fieldName, fieldValue, validations)
+	}
+	return nil
+}
+
+func validationFieldName(field reflect.StructField) string {
+	for _, tagName := range []string{"mapstructure", "json"} {
+		if name := strings.Split(field.Tag.Get(tagName), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return strings.ToLower(field.Name[:1]) + field.Name[1:]
+}
+
+// validationFieldValue returns the field's value as validated and whether
+// it's the zero value of it's type, so "required" works for all types. Nil
+// pointers are ""
+func validationFieldValue(value reflect.Value) (string, bool) {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return "", true
+		}
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.String {
+		return value.String(), value.String() == ""
+	}
+	return fmt.Sprint(value.Interface()), value.IsZero()
+}
+
+func validationIsNumber(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// validationField finds an exported struct field by form or Go name
+func validationField(value reflect.Value, name string) (reflect.Value, bool) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Name == name || validationFieldName(field) == name {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,73 @@
+package weeb
+
+import (
+	"testing"
+)
+
+func TestValidateStructNumberBounds(t *testing.T) {
+	form := struct {
+		Name  string  `validate:"min=3,max=5"`
+		Age   int     `validate:"min=13,max=130"`
+		Score float64 `validate:"max=9.5"`
+	}{Name: "éé", Age: 200, Score: 12}
+
+	v := NewValidator(nil)
+	if err := v.ValidateStruct(&form); err != nil {
+		t.Fatal(err)
+	}
+	fields := v.FieldErrors()
+	if len(fields["name"]) != 1 || fields["name"][0].Code != "min" {
+		t.Fatalf("expected name to be too short, got %v", fields["name"])
+	}
+	// 200 is 3 characters long but a number over 130
+	if len(fields["age"]) != 1 || fields["age"][0].Code != "maxvalue" {
+		t.Fatalf("expected age to be over its max, got %v", fields["age"])
+	}
+	if len(fields["score"]) != 1 || fields["score"][0].Message != "Score must be at most 9.5" {
+		t.Fatalf("expected score to be over its max, got %v", fields["score"])
+	}
+}
+
+func TestValidateStructZeroNumbers(t *testing.T) {
+	form := struct {
+		Qty      int      `validate:"min=1"`
+		Age      int      `validate:"range=13:130"`
+		Count    int      `validate:"required"`
+		Discount float64  `validate:"max=50"`
+		Optional *int     `validate:"min=1"`
+		Tags     []string `validate:"oneof=a b"`
+	}{}
+
+	v := NewValidator(nil)
+	if err := v.ValidateStruct(&form); err != nil {
+		t.Fatal(err)
+	}
+	fields := v.FieldErrors()
+	if len(fields["qty"]) != 1 || fields["qty"][0].Code != "minvalue" {
+		t.Fatalf("expected 0 to be under qty's min, got %v", fields["qty"])
+	}
+	if len(fields["age"]) != 1 || fields["age"][0].Code != "range" {
+		t.Fatalf("expected 0 to be out of age's range, got %v", fields["age"])
+	}
+	if len(fields["count"]) != 1 || fields["count"][0].Code != "required" {
+		t.Fatalf("expected a zero count to be missing, got %v", fields["count"])
+	}
+	if len(fields["discount"]) != 0 || len(fields["optional"]) != 0 || len(fields["tags"]) != 0 {
+		t.Fatalf("expected 0 under max, nil pointers and empty slices to pass, got %v", fields)
+	}
+}
+
+func TestValidateStructSkipsUnexportedFields(t *testing.T) {
+	form := struct {
+		Password     string `validate:"required"`
+		confirmation string `validate:"confirms=password"`
+	}{Password: "secret"}
+
+	v := NewValidator(nil)
+	if err := v.ValidateStruct(&form); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid() {
+		t.Fatalf("expected the unexported field to be ignored, got %v", v.Errors())
+	}
+}
@@ -0,0 +1,25 @@
+package weeb
+
+import (
+	"testing"
+)
+
+func TestValidateMessages(t *testing.T) {
+	noAdmin := func(ctx *Context, fieldName string, value string) []string {
+		if value == "admin" {
+			return []string{title(fieldName) + " is reserved"}
+		}
+		return []string{}
+	}
+
+	v := NewValidator(nil)
+	v.Validate("username", "admin", []ValidationFn{ValidateMessages(noAdmin)})
+	v.Validate("name", "admin", []ValidationFn{ValidatePresence()})
+	errors := v.FieldErrors()["username"]
+	if len(errors) != 1 || errors[0].Code != "invalid" || errors[0].Message != "Username is reserved" {
+		t.Fatalf("expected one invalid error, got %v", errors)
+	}
+	if len(v.Errors()) != 1 {
+		t.Fatalf("expected only the adapted check to fail, got %v", v.Errors())
+	}
+}